@@ -0,0 +1,71 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// acmeCacheDir returns the directory in the state directory where ACME account keys and certificates are stored.
+func (liveRoll *LiveRoll) acmeCacheDir() string {
+	return filepath.Join(liveRoll.StateDir, "acme")
+}
+
+// newACMEManager returns the manager obtaining and renewing the certificates of ACMEDomains.
+func (liveRoll *LiveRoll) newACMEManager() (*autocert.Manager, error) {
+	client := &acme.Client{DirectoryURL: liveRoll.ACMEDirectoryURL}
+	if liveRoll.ACMECAFile != "" {
+		pem, err := os.ReadFile(liveRoll.ACMECAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificate found in %s", liveRoll.ACMECAFile)
+		}
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+		client.HTTPClient = &http.Client{Transport: transport}
+	}
+	return &autocert.Manager{
+		Prompt:     autocert.AcceptTOS,
+		HostPolicy: autocert.HostWhitelist(liveRoll.ACMEDomains...),
+		Cache:      autocert.DirCache(liveRoll.acmeCacheDir()),
+		Email:      liveRoll.ACMEEmail,
+		Client:     client,
+	}, nil
+}
+
+// isACMEDomain reports whether the certificate for serverName is obtained through ACME.
+func (liveRoll *LiveRoll) isACMEDomain(serverName string) bool {
+	return slices.Contains(liveRoll.ACMEDomains, strings.ToLower(strings.TrimSuffix(serverName, ".")))
+}
+
+// acmeGetCertificate returns certificates from manager for ACME domains and TLS-ALPN-01 challenges,
+// and from fallback (the certificate files, if any) otherwise.
+func (liveRoll *LiveRoll) acmeGetCertificate(manager *autocert.Manager, fallback func(*tls.ClientHelloInfo) (*tls.Certificate, error)) func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	return func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		if fallback == nil || liveRoll.isACMEDomain(hello.ServerName) || slices.Contains(hello.SupportedProtos, acme.ALPNProto) {
+			return manager.GetCertificate(hello)
+		}
+		return fallback(hello)
+	}
+}
+
+// serveACMEChallenges answers HTTP-01 challenges on ACMEHTTPPort and redirects other requests to HTTPS.
+func (liveRoll *LiveRoll) serveACMEChallenges(manager *autocert.Manager) {
+	addr := fmt.Sprintf(":%d", liveRoll.ACMEHTTPPort)
+	log.Printf("Starting ACME HTTP-01 challenge server on %s", addr)
+	if err := http.ListenAndServe(addr, manager.HTTPHandler(nil)); err != nil {
+		log.Fatalf("ACME challenge server terminated: %v", err)
+	}
+}
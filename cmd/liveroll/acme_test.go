@@ -0,0 +1,65 @@
+package main
+
+import (
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"golang.org/x/crypto/acme"
+)
+
+// TestNewTLSConfig_ACME tests that ACME domains are served from the ACME cache in the state
+// directory and other names from the certificate files.
+func TestNewTLSConfig_ACME(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.StateDir = t.TempDir()
+	lr.ACMEDomains = []string{"a.example.com"}
+	lr.ACMEDirectoryURL = "https://localhost:14000/dir"
+	lr.ACMEHTTPPort = 0
+
+	// A certificate obtained earlier is stored in the cache as the key followed by the chain.
+	dir := t.TempDir()
+	certA, keyA := writeTestCertificate(t, dir, "a.example.com", 1)
+	certPEM, _ := os.ReadFile(certA)
+	keyPEM, _ := os.ReadFile(keyA)
+	if err := os.MkdirAll(lr.acmeCacheDir(), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(lr.acmeCacheDir(), "a.example.com"), append(keyPEM, certPEM...), 0600); err != nil {
+		t.Fatal(err)
+	}
+	certB, keyB := writeTestCertificate(t, dir, "b.example.com", 2)
+	lr.TLSCertFiles = []string{certB}
+	lr.TLSKeyFiles = []string{keyB}
+
+	config, err := lr.newTLSConfig()
+	if err != nil {
+		t.Fatalf("Failed to create TLS config: %v", err)
+	}
+	if !slices.Contains(config.NextProtos, acme.ALPNProto) {
+		t.Errorf("Expected %s in the ALPN protocols for TLS-ALPN-01, got %v", acme.ALPNProto, config.NextProtos)
+	}
+
+	for serverName, want := range map[string]int64{"a.example.com": 1, "b.example.com": 2} {
+		cert, err := config.GetCertificate(&tls.ClientHelloInfo{
+			ServerName:       serverName,
+			SignatureSchemes: []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+			SupportedCurves:  []tls.CurveID{tls.CurveP256},
+			CipherSuites:     []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		})
+		if err != nil {
+			t.Errorf("Failed to get the certificate of %s: %v", serverName, err)
+			continue
+		}
+		leaf := cert.Leaf
+		if leaf == nil {
+			t.Errorf("Expected a parsed certificate for %s", serverName)
+			continue
+		}
+		if leaf.SerialNumber.Int64() != want {
+			t.Errorf("Expected serial %d for %s, got %d", want, serverName, leaf.SerialNumber.Int64())
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+)
+
+// serveAdmin starts the admin API on the Unix socket AdminSocket.
+func (liveRoll *LiveRoll) serveAdmin() error {
+	// Remove a stale socket left by a previous run.
+	if err := os.Remove(liveRoll.AdminSocket); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	listener, err := net.Listen("unix", liveRoll.AdminSocket)
+	if err != nil {
+		return err
+	}
+	if err := os.Chmod(liveRoll.AdminSocket, 0o660); err != nil {
+		return err
+	}
+
+	log.Printf("Starting admin API on %s", liveRoll.AdminSocket)
+	go func() {
+		if err := http.Serve(listener, liveRoll.adminHandler()); err != nil {
+			log.Printf("Admin API terminated: %v", err)
+		}
+	}()
+	return nil
+}
+
+// adminHandler returns the handler of the admin API.
+func (liveRoll *LiveRoll) adminHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, _ *http.Request) {
+		writeJSON(w, http.StatusOK, liveRoll.status())
+	})
+	mux.HandleFunc("GET /history", func(w http.ResponseWriter, req *http.Request) {
+		writeJSON(w, http.StatusOK, liveRoll.rolloutHistory(req.URL.Query().Get("id")))
+	})
+	mux.HandleFunc("POST /promote", func(w http.ResponseWriter, _ *http.Request) {
+		liveRoll.writeAdminResult(w, liveRoll.decidePromotion(true), "promotion requested")
+	})
+	mux.HandleFunc("POST /abort", func(w http.ResponseWriter, _ *http.Request) {
+		liveRoll.writeAdminResult(w, liveRoll.decidePromotion(false), "abort requested")
+	})
+	mux.HandleFunc("POST /rollback", func(w http.ResponseWriter, req *http.Request) {
+		target, err := liveRoll.requestRollback(req.URL.Query().Get("id"))
+		liveRoll.writeAdminResult(w, err, fmt.Sprintf("rollback to %s requested", target))
+	})
+	mux.HandleFunc("POST /pin", func(w http.ResponseWriter, _ *http.Request) {
+		id, err := liveRoll.pinCurrent()
+		liveRoll.writeAdminResult(w, err, fmt.Sprintf("pinned to %s", id))
+	})
+	mux.HandleFunc("POST /unpin", func(w http.ResponseWriter, _ *http.Request) {
+		liveRoll.setPinnedID("")
+		liveRoll.writeAdminResult(w, nil, "unpinned")
+	})
+	return mux
+}
+
+// adminResponse is the body returned by the admin API for commands.
+type adminResponse struct {
+	Message string `json:"message,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// writeAdminResult writes the result of an admin command.
+func (liveRoll *LiveRoll) writeAdminResult(w http.ResponseWriter, err error, message string) {
+	if err != nil {
+		writeJSON(w, http.StatusConflict, adminResponse{Error: err.Error()})
+		return
+	}
+	log.Printf("Admin API: %s", message)
+	writeJSON(w, http.StatusOK, adminResponse{Message: message})
+}
+
+func writeJSON(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to write response: %v", err)
+	}
+}
+
+// adminRequestError is returned by the admin client for non-2xx responses.
+type adminRequestError struct {
+	status  int
+	message string
+}
+
+func (e *adminRequestError) Error() string {
+	return fmt.Sprintf("admin API returned %d: %s", e.status, e.message)
+}
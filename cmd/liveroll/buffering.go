@@ -0,0 +1,81 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/vulcand/oxy/v2/buffer"
+)
+
+// newBufferHandler wraps next in a handler buffering request and response bodies within the
+// configured limits. Retryable requests are sent again according to the retry policy,
+// preferring a backend they were not sent to yet. Limits of 0 keep oxy's defaults.
+func (liveRoll *LiveRoll) newBufferHandler(next http.Handler) (http.Handler, error) {
+	var opts []buffer.Option
+	if liveRoll.BufferMaxRequestBody > 0 {
+		opts = append(opts, buffer.MaxRequestBodyBytes(liveRoll.BufferMaxRequestBody))
+	}
+	if liveRoll.BufferMemRequestBody > 0 {
+		opts = append(opts, buffer.MemRequestBodyBytes(liveRoll.BufferMemRequestBody))
+	}
+	if liveRoll.BufferMaxResponseBody > 0 {
+		opts = append(opts, buffer.MaxResponseBodyBytes(liveRoll.BufferMaxResponseBody))
+	}
+	if liveRoll.BufferMemResponseBody > 0 {
+		opts = append(opts, buffer.MemResponseBodyBytes(liveRoll.BufferMemResponseBody))
+	}
+	buffered, err := buffer.New(next, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if liveRoll.RetryAttempts < 2 {
+		return buffered, nil
+	}
+	retrying, err := buffer.New(next, append(opts, buffer.Retry(liveRoll.retryPredicate()))...)
+	if err != nil {
+		return nil, err
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if !liveRoll.isRetryable(req) {
+			buffered.ServeHTTP(w, req)
+			return
+		}
+		ctx := context.WithValue(req.Context(), triedBackendsKey{}, map[int]bool{})
+		retrying.ServeHTTP(w, req.WithContext(ctx))
+	}), nil
+}
+
+// isStreamingRequest reports whether req matches a streaming path prefix, or a streaming
+// content type in its Content-Type (uploads) or Accept (downloads) header.
+func (liveRoll *LiveRoll) isStreamingRequest(req *http.Request) bool {
+	for _, prefix := range liveRoll.StreamingPaths {
+		if strings.HasPrefix(req.URL.Path, prefix) {
+			return true
+		}
+	}
+	if len(liveRoll.StreamingContentTypes) == 0 {
+		return false
+	}
+	contentType := strings.ToLower(req.Header.Get("Content-Type"))
+	accept := strings.ToLower(req.Header.Get("Accept"))
+	for _, t := range liveRoll.StreamingContentTypes {
+		if strings.HasPrefix(contentType, t) || strings.Contains(accept, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// withStreaming sends long-lived requests, gRPC calls and streaming requests straight to the
+// load balancer, bypassing next (the response buffer), so that they are neither held back nor
+// limited in size. They are not retried.
+func (liveRoll *LiveRoll) withStreaming(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if isLongLived(req) || isGRPC(req) || liveRoll.isStreamingRequest(req) {
+			liveRoll.lb.ServeHTTP(w, req)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestBuffering_Limits tests that bodies over the configured limits are rejected unless the
+// request is streamed.
+func TestBuffering_Limits(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(strings.Repeat("x", 100)))
+	}))
+	defer backend.Close()
+
+	lr := createTestLiveRoll()
+	lr.BufferMaxRequestBody = 10
+	lr.BufferMaxResponseBody = 10
+	lr.StreamingPaths = []string{"/upload"}
+	_, proxyURL := createTestProxy(t, lr, backend)
+
+	for _, tc := range []struct {
+		path string
+		body string
+		want int
+	}{
+		{"/small", "12345", http.StatusInternalServerError},
+		{"/large", strings.Repeat("y", 100), http.StatusRequestEntityTooLarge},
+		{"/upload", strings.Repeat("y", 100), http.StatusOK},
+	} {
+		resp, err := http.Post(proxyURL+tc.path, "text/plain", strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatalf("Failed to request %s: %v", tc.path, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.want {
+			t.Errorf("Expected %d for %s, got %d", tc.want, tc.path, resp.StatusCode)
+		}
+	}
+}
+
+// TestBuffering_StreamingContentType tests that responses of a streaming content type are
+// delivered while the backend is still writing them.
+func TestBuffering_StreamingContentType(t *testing.T) {
+	release := make(chan struct{})
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Write([]byte("first\n"))
+		w.(http.Flusher).Flush()
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+		w.Write([]byte("second\n"))
+	}))
+	defer backend.Close()
+	defer close(release)
+
+	lr := createTestLiveRoll()
+	lr.StreamingContentTypes = []string{"application/x-ndjson"}
+	_, proxyURL := createTestProxy(t, lr, backend)
+
+	req, _ := http.NewRequest(http.MethodGet, proxyURL+"/feed", nil)
+	req.Header.Set("Accept", "application/x-ndjson")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	lines := make(chan string, 1)
+	go func() {
+		line, _ := bufio.NewReader(resp.Body).ReadString('\n')
+		lines <- line
+	}()
+	select {
+	case line := <-lines:
+		if line != "first\n" {
+			t.Errorf("Expected the first line, got %q", line)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the first line before the response completed")
+	}
+}
+
+// TestIsStreamingRequest tests the matching of streaming paths and content types.
+func TestIsStreamingRequest(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.StreamingPaths = []string{"/upload/"}
+	lr.StreamingContentTypes = []string{"video/"}
+
+	for _, tc := range []struct {
+		path, contentType, accept string
+		want                      bool
+	}{
+		{"/upload/a", "", "", true},
+		{"/uploads", "", "", false},
+		{"/", "video/mp4", "", true},
+		{"/", "", "text/html, video/webm;q=0.9", true},
+		{"/", "text/plain", "*/*", false},
+	} {
+		req := httptest.NewRequest(http.MethodPost, tc.path, nil)
+		if tc.contentType != "" {
+			req.Header.Set("Content-Type", tc.contentType)
+		}
+		if tc.accept != "" {
+			req.Header.Set("Accept", tc.accept)
+		}
+		if got := lr.isStreamingRequest(req); got != tc.want {
+			t.Errorf("isStreamingRequest(%s, %q, %q) = %v, want %v", tc.path, tc.contentType, tc.accept, got, tc.want)
+		}
+	}
+}
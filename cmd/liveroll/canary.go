@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vulcand/oxy/v2/roundrobin"
+)
+
+// bakeCheckInterval is how often the new child process is checked while baking.
+const bakeCheckInterval = 500 * time.Millisecond
+
+// parseCanarySteps parses a comma separated list of traffic percentages, e.g. "5,25,50,100".
+func parseCanarySteps(s string) ([]int, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var steps []int
+	prev := 0
+	for _, field := range strings.Split(s, ",") {
+		pct, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid canary step %q: %v", field, err)
+		}
+		if pct <= prev || pct > 100 {
+			return nil, fmt.Errorf("canary steps must be increasing percentages between 1 and 100: %q", s)
+		}
+		steps = append(steps, pct)
+		prev = pct
+	}
+	return steps, nil
+}
+
+// canaryRollout adds the new child process to the load balancer and shifts traffic to it
+// through CanarySteps, baking for CanaryBake at each step. On failure, traffic is shifted
+// back to the old backends and the new child process is removed from the load balancer.
+func (liveRoll *LiveRoll) canaryRollout(child *ChildProcess) error {
+	oldPorts := liveRoll.otherBackendPorts(child.port)
+	if len(oldPorts) == 0 {
+		log.Printf("No backend to shift traffic from. Adding port %d without canary steps", child.port)
+		liveRoll.addBackend(child)
+		return nil
+	}
+
+	liveRoll.traffic.reset()
+	for i, pct := range liveRoll.CanarySteps {
+		if pct >= 100 {
+			break
+		}
+		// Weights are relative, so the new backend gets pct for every old backend.
+		newWeight := pct * len(oldPorts)
+		if i == 0 {
+			liveRoll.addBackendWithWeight(child, newWeight)
+		} else {
+			liveRoll.setBackendWeight(child.port, newWeight)
+		}
+		for _, port := range oldPorts {
+			liveRoll.setBackendWeight(port, 100-pct)
+		}
+		log.Printf("Canary step %d/%d: sending %d%% of traffic to port %d for %v",
+			i+1, len(liveRoll.CanarySteps), pct, child.port, liveRoll.CanaryBake)
+
+		if err := liveRoll.bake(child, oldPorts, liveRoll.CanaryBake); err != nil {
+			log.Printf("Canary failed at %d%%: %v. Shifting traffic back", pct, err)
+			liveRoll.removeBackend(child)
+			for _, port := range oldPorts {
+				liveRoll.setBackendWeight(port, 1)
+			}
+			return err
+		}
+	}
+
+	// Send all traffic to the new backend until the old ones are removed.
+	if _, ok := liveRoll.backendWeight(child.port); !ok {
+		liveRoll.addBackendWithWeight(child, 1)
+	} else {
+		liveRoll.setBackendWeight(child.port, 1)
+	}
+	for _, port := range oldPorts {
+		liveRoll.setBackendWeight(port, 0)
+	}
+	log.Printf("Canary completed: sending 100%% of traffic to port %d", child.port)
+	return nil
+}
+
+// bake waits for d while making sure that the new child process keeps running and,
+// if rollback thresholds are configured, does not regress compared to the old backends.
+func (liveRoll *LiveRoll) bake(child *ChildProcess, oldPorts []int, d time.Duration) error {
+	deadline := time.Now().Add(d)
+	for {
+		if !liveRoll.isChildRegistered(child) {
+			return fmt.Errorf("child process on port %d exited during bake", child.port)
+		}
+		if liveRoll.regressionCheckEnabled() {
+			c := liveRoll.compareTraffic(child.port, oldPorts)
+			liveRoll.recordComparison(c)
+			if c.Decision == decisionRollback {
+				logTrafficComparison(c)
+				return &regressionError{comparison: c}
+			}
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			if liveRoll.regressionCheckEnabled() {
+				logTrafficComparison(liveRoll.compareTraffic(child.port, oldPorts))
+			}
+			return nil
+		}
+		time.Sleep(min(remaining, bakeCheckInterval))
+	}
+}
+
+// isChildRegistered reports whether child is still the managed child process on its port.
+func (liveRoll *LiveRoll) isChildRegistered(child *ChildProcess) bool {
+	liveRoll.childrenMutex.Lock()
+	defer liveRoll.childrenMutex.Unlock()
+	return liveRoll.children[child.port] == child
+}
+
+// otherBackendPorts returns the ports of the registered backends except port.
+func (liveRoll *LiveRoll) otherBackendPorts(port int) []int {
+	liveRoll.backendURLsMutex.Lock()
+	defer liveRoll.backendURLsMutex.Unlock()
+	var ports []int
+	for p := range liveRoll.backendURLs {
+		if p != port {
+			ports = append(ports, p)
+		}
+	}
+	sort.Ints(ports)
+	return ports
+}
+
+// backendWeight returns the load balancer weight of the backend on port.
+func (liveRoll *LiveRoll) backendWeight(port int) (int, bool) {
+	liveRoll.backendURLsMutex.Lock()
+	defer liveRoll.backendURLsMutex.Unlock()
+	weight, ok := liveRoll.backendWeights[port]
+	return weight, ok
+}
+
+// setBackendWeight changes the load balancer weight of the backend on port.
+// A weight of 0 keeps the backend registered without sending new requests to it.
+func (liveRoll *LiveRoll) setBackendWeight(port int, weight int) {
+	liveRoll.backendURLsMutex.Lock()
+	defer liveRoll.backendURLsMutex.Unlock()
+	u, ok := liveRoll.backendURLs[port]
+	if !ok {
+		return
+	}
+	liveRoll.backendWeights[port] = weight
+	if _, ejected := liveRoll.ejectedUntil(port); ejected {
+		// The weight is applied when the backend is restored.
+		return
+	}
+	if err := liveRoll.lb.UpsertServer(u, roundrobin.Weight(weight)); err != nil {
+		log.Printf("[ERROR] Failed to set weight of backend on port %d: %v", port, err)
+	}
+	liveRoll.notifyBackendsChanged()
+}
+
+// resetBackendWeights sets the weight of every registered backend back to 1.
+func (liveRoll *LiveRoll) resetBackendWeights() {
+	for _, port := range liveRoll.otherBackendPorts(0) {
+		if weight, _ := liveRoll.backendWeight(port); weight != 1 {
+			liveRoll.setBackendWeight(port, 1)
+		}
+	}
+}
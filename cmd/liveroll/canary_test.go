@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseCanarySteps tests parsing of the --canary-steps flag.
+func TestParseCanarySteps(t *testing.T) {
+	steps, err := parseCanarySteps("5, 25,50,100")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(steps) != 4 || steps[0] != 5 || steps[3] != 100 {
+		t.Errorf("Unexpected steps: %v", steps)
+	}
+
+	for _, invalid := range []string{"50,25", "0,50", "5,101", "five"} {
+		if _, err := parseCanarySteps(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+// TestCanaryRollout tests that traffic ends up on the new backend after all steps.
+func TestCanaryRollout(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t, 9101)
+	lr.CanarySteps = []int{10, 50, 100}
+	lr.CanaryBake = 10 * time.Millisecond
+
+	child := &ChildProcess{port: 9102, id: "new"}
+	lr.children[9101] = &ChildProcess{port: 9101, id: "old"}
+	lr.children[9102] = child
+
+	if err := lr.canaryRollout(child); err != nil {
+		t.Fatalf("Expected canary to succeed, got: %v", err)
+	}
+	if w, _ := lr.backendWeight(9102); w != 1 {
+		t.Errorf("Expected new backend weight 1, got %d", w)
+	}
+	if w, _ := lr.backendWeight(9101); w != 0 {
+		t.Errorf("Expected old backend weight 0, got %d", w)
+	}
+}
+
+// TestCanaryRollout_ChildExited tests that traffic is shifted back when the new child exits during bake.
+func TestCanaryRollout_ChildExited(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t, 9101)
+	lr.CanarySteps = []int{10, 100}
+	lr.CanaryBake = 10 * time.Millisecond
+
+	// The new child process is not registered, as if it had already exited.
+	child := &ChildProcess{port: 9102, id: "new"}
+	lr.children[9101] = &ChildProcess{port: 9101, id: "old"}
+
+	if err := lr.canaryRollout(child); err == nil {
+		t.Fatal("Expected canary to fail")
+	}
+	if _, ok := lr.backendWeight(9102); ok {
+		t.Error("Expected new backend to be removed from the load balancer")
+	}
+	if w, _ := lr.backendWeight(9101); w != 1 {
+		t.Errorf("Expected old backend weight to be restored to 1, got %d", w)
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"time"
+)
+
+// subcommands talking to a running liveroll through the admin socket.
+var subcommands = map[string]struct {
+	method string
+	path   string
+	usage  string
+	// takesID is true if the subcommand accepts an optional ID argument.
+	takesID bool
+	// readsStateDir is true if the subcommand can read the state directory instead of
+	// asking the running liveroll.
+	readsStateDir bool
+}{
+	"status":   {http.MethodGet, "/status", "Show the status of the running liveroll", false, false},
+	"history":  {http.MethodGet, "/history", "Show the update processes, optionally only those from or to ID", true, true},
+	"promote":  {http.MethodPost, "/promote", "Promote the candidate waiting for promotion", false, false},
+	"abort":    {http.MethodPost, "/abort", "Abort the candidate waiting for promotion", false, false},
+	"rollback": {http.MethodPost, "/rollback", "Relaunch the previous (or the given) deployed ID and pin it", true, false},
+	"pin":      {http.MethodPost, "/pin", "Pin the current ID and skip updates until unpinned", false, false},
+	"unpin":    {http.MethodPost, "/unpin", "Clear the pin and resume updates", false, false},
+}
+
+// isSubcommand reports whether the command line starts with a subcommand instead of flags.
+func isSubcommand(args []string) bool {
+	return len(args) > 0 && !strings.HasPrefix(args[0], "-")
+}
+
+// runSubcommand executes a subcommand and writes its result to out.
+func runSubcommand(name string, args []string, out io.Writer) error {
+	sub, ok := subcommands[name]
+	if !ok {
+		return fmt.Errorf("unknown subcommand %q", name)
+	}
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	adminSocket := fs.String("admin-socket", "", "Path of the admin socket of the running liveroll")
+	stateDir := ""
+	if sub.readsStateDir {
+		fs.StringVar(&stateDir, "state-dir", "", "State directory of liveroll, read directly instead of asking the running liveroll")
+	}
+	fs.Usage = func() {
+		source := "--admin-socket PATH"
+		if sub.readsStateDir {
+			source = "(--admin-socket PATH | --state-dir DIR)"
+		}
+		idArg := ""
+		if sub.takesID {
+			idArg = " [ID]"
+		}
+		fmt.Fprintf(fs.Output(), "Usage: liveroll %s %s%s\n\n%s\n", name, source, idArg, sub.usage)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	path := sub.path
+	id := ""
+	switch {
+	case sub.takesID && fs.NArg() == 1:
+		id = fs.Arg(0)
+		path += "?id=" + url.QueryEscape(id)
+	case fs.NArg() > 0 && !sub.takesID, fs.NArg() > 1:
+		return fmt.Errorf("unexpected arguments: %v", fs.Args())
+	}
+
+	if stateDir != "" {
+		// The history files are complete, while the running liveroll keeps only the recent entries.
+		history, err := readHistory(stateDir)
+		if err != nil {
+			return err
+		}
+		body, err := json.Marshal(filterHistory(history, id))
+		if err != nil {
+			return err
+		}
+		return printAdminResponse(out, body)
+	}
+	if *adminSocket == "" {
+		if sub.readsStateDir {
+			return fmt.Errorf("--admin-socket or --state-dir is required")
+		}
+		return fmt.Errorf("--admin-socket is required")
+	}
+
+	body, err := adminRequest(*adminSocket, sub.method, path)
+	if err != nil {
+		return err
+	}
+	return printAdminResponse(out, body)
+}
+
+// adminRequest sends a request to the admin API listening on socketPath.
+func adminRequest(socketPath string, method string, path string) ([]byte, error) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", socketPath)
+			},
+		},
+	}
+	req, err := http.NewRequest(method, "http://liveroll"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		var r adminResponse
+		if json.Unmarshal(body, &r) == nil && r.Error != "" {
+			return nil, &adminRequestError{status: resp.StatusCode, message: r.Error}
+		}
+		return nil, &adminRequestError{status: resp.StatusCode, message: strings.TrimSpace(string(body))}
+	}
+	return body, nil
+}
+
+// printAdminResponse prints the message of a command, or the indented JSON of other responses.
+func printAdminResponse(out io.Writer, body []byte) error {
+	var r adminResponse
+	if json.Unmarshal(body, &r) == nil && r.Message != "" {
+		_, err := fmt.Fprintln(out, r.Message)
+		return err
+	}
+	var indented bytes.Buffer
+	if err := json.Indent(&indented, body, "", "  "); err != nil {
+		_, err = out.Write(body)
+		return err
+	}
+	_, err := fmt.Fprintln(out, strings.TrimSpace(indented.String()))
+	return err
+}
+
+// printUsage prints the usage of liveroll including the subcommands.
+func printUsage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: liveroll [flags]\n       liveroll <subcommand> [flags]\n\nSubcommands:\n")
+	for _, name := range sortedSubcommands() {
+		fmt.Fprintf(out, "  %-10s %s\n", name, subcommands[name].usage)
+	}
+	fmt.Fprintf(out, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+func sortedSubcommands() []string {
+	names := make([]string, 0, len(subcommands))
+	for name := range subcommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// exitWithError prints err and exits with a non-zero status.
+func exitWithError(err error) {
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(2)
+	}
+	fmt.Fprintf(os.Stderr, "liveroll: %v\n", err)
+	os.Exit(1)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// startTestAdmin starts the admin API of lr on a temporary socket.
+func startTestAdmin(t *testing.T, lr *LiveRoll) string {
+	t.Helper()
+	lr.AdminSocket = filepath.Join(t.TempDir(), "admin.sock")
+	if err := lr.serveAdmin(); err != nil {
+		t.Fatalf("Failed to start admin API: %v", err)
+	}
+	return lr.AdminSocket
+}
+
+// TestIsSubcommand tests the detection of subcommands on the command line.
+func TestIsSubcommand(t *testing.T) {
+	if isSubcommand([]string{"--pull", "true"}) || isSubcommand(nil) {
+		t.Error("Expected flags not to be detected as a subcommand")
+	}
+	if !isSubcommand([]string{"status", "--admin-socket", "x"}) {
+		t.Error("Expected status to be detected as a subcommand")
+	}
+}
+
+// TestRunSubcommand_Status tests the status subcommand against the admin API.
+func TestRunSubcommand_Status(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t)
+	lr.currentID = "v1"
+	socket := startTestAdmin(t, lr)
+
+	var out bytes.Buffer
+	if err := runSubcommand("status", []string{"--admin-socket", socket}, &out); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	var st Status
+	if err := json.Unmarshal(out.Bytes(), &st); err != nil {
+		t.Fatalf("Failed to decode output %q: %v", out.String(), err)
+	}
+	if st.CurrentID != "v1" {
+		t.Errorf("Expected current ID v1, got %q", st.CurrentID)
+	}
+}
+
+// TestRunSubcommand_Promote tests that promote reports an error when no candidate is waiting.
+func TestRunSubcommand_Promote(t *testing.T) {
+	lr := createTestLiveRoll()
+	socket := startTestAdmin(t, lr)
+
+	var out bytes.Buffer
+	err := runSubcommand("promote", []string{"--admin-socket", socket}, &out)
+	if err == nil || !strings.Contains(err.Error(), errNoCandidate.Error()) {
+		t.Errorf("Expected %q error, got: %v", errNoCandidate, err)
+	}
+
+	if err := runSubcommand("promote", nil, &out); err == nil {
+		t.Error("Expected error without --admin-socket")
+	}
+	if err := runSubcommand("unknown", nil, &out); err == nil {
+		t.Error("Expected error for an unknown subcommand")
+	}
+}
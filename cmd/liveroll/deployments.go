@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maxDeployments is the number of successfully deployed IDs remembered for rollbacks.
+const maxDeployments = 20
+
+// Deployment is an ID that was successfully promoted.
+type Deployment struct {
+	ID         string    `json:"id"`
+	DeployedAt time.Time `json:"deployed_at"`
+	// RolledBackFrom is the ID replaced by this deployment when it was a rollback.
+	RolledBackFrom string `json:"rolled_back_from,omitempty"`
+}
+
+// deploymentsPath returns the path of the deployment history in the state directory.
+func (liveRoll *LiveRoll) deploymentsPath() string {
+	return filepath.Join(liveRoll.StateDir, "deployments.json")
+}
+
+// loadDeployments reads the deployment history from the state directory.
+func (liveRoll *LiveRoll) loadDeployments() error {
+	if liveRoll.StateDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(liveRoll.deploymentsPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var deployments []Deployment
+	if err := json.Unmarshal(data, &deployments); err != nil {
+		return fmt.Errorf("failed to parse %s: %v", liveRoll.deploymentsPath(), err)
+	}
+
+	liveRoll.currentIDMutex.Lock()
+	liveRoll.deployments = deployments
+	liveRoll.currentIDMutex.Unlock()
+	return nil
+}
+
+// recordDeployment appends id to the deployment history and saves it to the state directory.
+func (liveRoll *LiveRoll) recordDeployment(id string) {
+	liveRoll.appendDeployment(Deployment{ID: id, DeployedAt: time.Now()})
+}
+
+// recordRollback appends id to the deployment history as a rollback from the ID from.
+func (liveRoll *LiveRoll) recordRollback(id string, from string) {
+	liveRoll.appendDeployment(Deployment{ID: id, DeployedAt: time.Now(), RolledBackFrom: from})
+}
+
+// appendDeployment appends d to the deployment history and saves it to the state directory.
+func (liveRoll *LiveRoll) appendDeployment(d Deployment) {
+	liveRoll.currentIDMutex.Lock()
+	n := len(liveRoll.deployments)
+	if n > 0 && liveRoll.deployments[n-1].ID == d.ID {
+		liveRoll.deployments[n-1].DeployedAt = d.DeployedAt
+		if d.RolledBackFrom != "" {
+			liveRoll.deployments[n-1].RolledBackFrom = d.RolledBackFrom
+		}
+	} else {
+		liveRoll.deployments = append(liveRoll.deployments, d)
+	}
+	if len(liveRoll.deployments) > maxDeployments {
+		liveRoll.deployments = liveRoll.deployments[len(liveRoll.deployments)-maxDeployments:]
+	}
+	deployments := append([]Deployment(nil), liveRoll.deployments...)
+	liveRoll.currentIDMutex.Unlock()
+
+	if liveRoll.StateDir == "" {
+		return
+	}
+	data, err := json.MarshalIndent(deployments, "", "  ")
+	if err != nil {
+		log.Printf("Failed to encode deployment history: %v", err)
+		return
+	}
+	if err := writeFileAtomic(liveRoll.deploymentsPath(), data); err != nil {
+		log.Printf("Failed to save deployment history: %v", err)
+	}
+}
+
+// deploymentHistory returns a copy of the deployment history, oldest first.
+func (liveRoll *LiveRoll) deploymentHistory() []Deployment {
+	liveRoll.currentIDMutex.Lock()
+	defer liveRoll.currentIDMutex.Unlock()
+	return append([]Deployment(nil), liveRoll.deployments...)
+}
+
+// rollbackTarget resolves the ID to roll back to. An empty id selects the most recent
+// deployed ID other than the current one that was not rolled back from since it was deployed;
+// otherwise id must be in the deployment history.
+func (liveRoll *LiveRoll) rollbackTarget(id string) (string, error) {
+	liveRoll.currentIDMutex.Lock()
+	defer liveRoll.currentIDMutex.Unlock()
+	rolledBack := make(map[string]bool)
+	for i := len(liveRoll.deployments) - 1; i >= 0; i-- {
+		d := liveRoll.deployments[i]
+		if id == "" && d.ID != liveRoll.currentID && !rolledBack[d.ID] {
+			return d.ID, nil
+		}
+		if id != "" && d.ID == id {
+			return d.ID, nil
+		}
+		if d.RolledBackFrom != "" {
+			rolledBack[d.RolledBackFrom] = true
+		}
+	}
+	if id == "" {
+		return "", fmt.Errorf("no previous ID in the deployment history")
+	}
+	return "", fmt.Errorf("ID %q is not in the deployment history", id)
+}
+
+// requestRollback queues an update process relaunching a previously deployed ID.
+func (liveRoll *LiveRoll) requestRollback(id string) (string, error) {
+	target, err := liveRoll.rollbackTarget(id)
+	if err != nil {
+		return "", err
+	}
+	if !liveRoll.requestUpdate(updateRequest{trigger: triggerRollback, forced: true, rollbackID: target}) {
+		return "", fmt.Errorf("liveroll is shutting down")
+	}
+	return target, nil
+}
+
+// writeFileAtomic writes data to a temporary file and renames it to path.
+func writeFileAtomic(path string, data []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestRecordDeployment tests that the deployment history is persisted in the state directory.
+func TestRecordDeployment(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.StateDir = t.TempDir()
+
+	lr.recordDeployment("v1")
+	lr.recordDeployment("v2")
+	lr.recordDeployment("v2")
+
+	restored := createTestLiveRoll()
+	restored.StateDir = lr.StateDir
+	if err := restored.loadDeployments(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	history := restored.deploymentHistory()
+	if len(history) != 2 || history[0].ID != "v1" || history[1].ID != "v2" {
+		t.Errorf("Unexpected deployment history: %+v", history)
+	}
+}
+
+// TestRollbackTarget tests the selection of the ID to roll back to.
+func TestRollbackTarget(t *testing.T) {
+	lr := createTestLiveRoll()
+	if _, err := lr.rollbackTarget(""); err == nil {
+		t.Error("Expected error without deployment history")
+	}
+
+	lr.recordDeployment("v1")
+	lr.recordDeployment("v2")
+	lr.currentID = "v2"
+
+	if id, err := lr.rollbackTarget(""); err != nil || id != "v1" {
+		t.Errorf("Expected previous ID v1, got %q (%v)", id, err)
+	}
+	if id, err := lr.rollbackTarget("v2"); err != nil || id != "v2" {
+		t.Errorf("Expected chosen ID v2, got %q (%v)", id, err)
+	}
+	if _, err := lr.rollbackTarget("v3"); err == nil {
+		t.Error("Expected error for an ID not in the deployment history")
+	}
+}
+
+// TestRollbackTarget_SkipsRolledBack tests that the default rollback target is not an ID
+// that was rolled back from, unless it was deployed again since.
+func TestRollbackTarget_SkipsRolledBack(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.recordDeployment("v1")
+	lr.recordDeployment("v2")
+	lr.recordDeployment("v3")
+	lr.recordRollback("v2", "v3")
+	lr.currentID = "v2"
+
+	if id, err := lr.rollbackTarget(""); err != nil || id != "v1" {
+		t.Errorf("Expected v1 rather than the rolled back v3, got %q (%v)", id, err)
+	}
+	if id, err := lr.rollbackTarget("v3"); err != nil || id != "v3" {
+		t.Errorf("Expected v3 to be chosen explicitly, got %q (%v)", id, err)
+	}
+
+	lr.recordDeployment("v3")
+	lr.currentID = "v3"
+	if id, err := lr.rollbackTarget(""); err != nil || id != "v2" {
+		t.Errorf("Expected v2 after v3 was deployed again, got %q (%v)", id, err)
+	}
+}
+
+// TestRequestRollback tests that a rollback is queued as a forced update without pull.
+func TestRequestRollback(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.recordDeployment("v1")
+	lr.recordDeployment("v2")
+	lr.currentID = "v2"
+
+	target, err := lr.requestRollback("")
+	if err != nil || target != "v1" {
+		t.Fatalf("Expected rollback to v1, got %q (%v)", target, err)
+	}
+	req := <-lr.updateChan
+	if !req.forced || req.rollbackID != "v1" {
+		t.Errorf("Unexpected update request: %+v", req)
+	}
+}
+
+// TestUpdateProcess_Pinned tests that non-forced updates are skipped while pinned.
+func TestUpdateProcess_Pinned(t *testing.T) {
+	lr := createTestLiveRoll()
+	// The pull command would fail if it were executed.
+	lr.PullCmdStr = "false"
+	lr.setPinnedID("v1")
+
+	result := lr.beginRollout(updateRequest{})
+	err := lr.updateProcess(updateRequest{forced: false})
+	lr.finishRollout(result, err)
+	if err != nil {
+		t.Errorf("Expected update to be skipped, got: %v", err)
+	}
+	if last := lr.lastRolloutResult(); last.Outcome != outcomeSkipped {
+		t.Errorf("Expected outcome %q, got %q", outcomeSkipped, last.Outcome)
+	}
+}
+
+// TestRequestUpdate_Merge tests that update requests queued while an update process runs are
+// merged instead of dropped, so that an interval tick never blocks an operator command.
+func TestRequestUpdate_Merge(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.recordDeployment("v1")
+	lr.recordDeployment("v2")
+	lr.currentID = "v2"
+
+	lr.triggerUpdate(triggerInterval, false)
+	target, err := lr.requestRollback("")
+	if err != nil || target != "v1" {
+		t.Fatalf("Expected rollback to v1 to be queued, got %q (%v)", target, err)
+	}
+	lr.triggerUpdate(triggerInterval, false)
+
+	req := <-lr.updateChan
+	if req.trigger != triggerRollback || !req.forced || req.rollbackID != "v1" {
+		t.Errorf("Expected the rollback to win, got %+v", req)
+	}
+
+	lr.triggerUpdate(triggerInterval, false)
+	lr.triggerUpdate(triggerSignal, true)
+	lr.triggerUpdate(triggerInterval, false)
+	req = <-lr.updateChan
+	if req.trigger != triggerInterval || !req.forced || req.rollbackID != "" {
+		t.Errorf("Expected a forced update with the newest trigger, got %+v", req)
+	}
+	select {
+	case req := <-lr.updateChan:
+		t.Errorf("Expected a single queued request, got another: %+v", req)
+	default:
+	}
+}
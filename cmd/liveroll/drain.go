@@ -0,0 +1,145 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// drainCheckInterval is how often the in-flight requests of a draining backend are checked.
+const drainCheckInterval = 10 * time.Millisecond
+
+// inFlightRequests counts the requests being proxied to each backend port.
+type inFlightRequests struct {
+	mutex  sync.Mutex
+	counts map[int]int
+	// last is when a request to each port last started or completed
+	last map[int]time.Time
+}
+
+func newInFlightRequests() *inFlightRequests {
+	return &inFlightRequests{counts: make(map[int]int), last: make(map[int]time.Time)}
+}
+
+// add adjusts the number of in-flight requests to port by delta.
+func (f *inFlightRequests) add(port int, delta int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.last[port] = time.Now()
+	f.counts[port] += delta
+	if f.counts[port] <= 0 {
+		delete(f.counts, port)
+	}
+}
+
+// idleSince returns when the last request to port completed, but not before since,
+// or the zero time if a request is in flight.
+func (f *inFlightRequests) idleSince(port int, since time.Time) time.Time {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if f.counts[port] > 0 {
+		return time.Time{}
+	}
+	if last := f.last[port]; last.After(since) {
+		return last
+	}
+	return since
+}
+
+// count returns the number of in-flight requests to port.
+func (f *inFlightRequests) count(port int) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	return f.counts[port]
+}
+
+// waitUntil waits until done returns true or deadline passes, and reports whether done returned true.
+func waitUntil(done func() bool, deadline time.Time) bool {
+	for {
+		if done() {
+			return true
+		}
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(drainCheckInterval)
+	}
+}
+
+// drainAndStop removes the children from the load balancer once their sticky clients were
+// served for StickyDrainTimeout, waits until their in-flight requests complete or DrainTimeout
+// expires, closes the long-lived connections remaining after LongLivedDrainTimeout, and then
+// terminates them.
+func (liveRoll *LiveRoll) drainAndStop(children []*ChildProcess) {
+	if len(children) == 0 {
+		return
+	}
+
+	liveRoll.childrenMutex.Lock()
+	for _, child := range children {
+		liveRoll.draining[child.port] = child
+	}
+	liveRoll.childrenMutex.Unlock()
+	defer func() {
+		liveRoll.childrenMutex.Lock()
+		for _, child := range children {
+			if liveRoll.draining[child.port] == child {
+				delete(liveRoll.draining, child.port)
+			}
+		}
+		liveRoll.childrenMutex.Unlock()
+	}()
+
+	liveRoll.drainStickySessions(children)
+	for _, child := range children {
+		liveRoll.removeBackend(child)
+	}
+
+	deadline := time.Now().Add(liveRoll.DrainTimeout)
+	longLivedDeadline := time.Now().Add(liveRoll.LongLivedDrainTimeout)
+	for _, child := range children {
+		port := child.port
+		if n := liveRoll.inFlight.count(port); n > 0 {
+			log.Printf("Draining %d in-flight request(s) of the old child process on port %d", n, port)
+		}
+		if !waitUntil(func() bool { return liveRoll.inFlight.count(port) == 0 }, deadline) {
+			log.Printf("Drain timeout of %v expired with %d in-flight request(s) on port %d",
+				liveRoll.DrainTimeout, liveRoll.inFlight.count(port), port)
+		}
+	}
+	for _, child := range children {
+		port := child.port
+		if n := liveRoll.longLived.count(port); n > 0 {
+			log.Printf("Waiting for %d long-lived connection(s) to the old child process on port %d", n, port)
+		}
+		if waitUntil(func() bool { return liveRoll.longLived.count(port) == 0 }, longLivedDeadline) {
+			continue
+		}
+		conns := liveRoll.longLived.forPort(port)
+		log.Printf("Closing %d long-lived connection(s) to port %d", len(conns), port)
+		for _, conn := range conns {
+			conn.close(liveRoll.WebSocketCloseFrame)
+		}
+		waitUntil(func() bool { return liveRoll.longLived.count(port) == 0 }, time.Now().Add(time.Second))
+	}
+
+	for _, child := range children {
+		if child.cmd == nil || child.cmd.Process == nil {
+			continue
+		}
+		log.Printf("Sending SIGTERM to the old child process on port %d, pid=%v", child.port, child.cmd.Process.Pid)
+		if err := child.cmd.Process.Signal(syscall.SIGTERM); err != nil {
+			log.Printf("Failed to send SIGTERM to child process on port %d, pid %v: %v",
+				child.port, child.cmd.Process.Pid, err)
+		}
+	}
+	for _, child := range children {
+		if child.cmd == nil || child.cmd.Process == nil {
+			continue
+		}
+		if !waitChildExit(child, 10*time.Second) {
+			killChild(child)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+// startSleepChild starts a long running process registered as a child on port.
+func startSleepChild(t *testing.T, lr *LiveRoll, port int, id string) *ChildProcess {
+	t.Helper()
+	cmd := exec.Command("sleep", "30")
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Failed to start sleep: %v", err)
+	}
+	child := &ChildProcess{port: port, id: id, cmd: cmd, exited: make(chan struct{})}
+	go func() {
+		_ = cmd.Wait()
+		close(child.exited)
+	}()
+	t.Cleanup(func() { killChild(child) })
+	lr.children[port] = child
+	lr.addBackend(child)
+	return child
+}
+
+// hasBackend reports whether port is registered in the load balancer.
+func hasBackend(lr *LiveRoll, port int) bool {
+	lr.backendURLsMutex.Lock()
+	defer lr.backendURLsMutex.Unlock()
+	_, ok := lr.backendURLs[port]
+	return ok
+}
+
+// TestRemoveStaleChildren_Drain tests that an old child is unregistered and removed from the
+// load balancer first, and only stopped after its in-flight requests complete.
+func TestRemoveStaleChildren_Drain(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t)
+	lr.DrainTimeout = 10 * time.Second
+	old := startSleepChild(t, lr, 9101, "old")
+	startSleepChild(t, lr, 9102, "new")
+	lr.inFlight.add(9101, 1)
+
+	start := time.Now()
+	lr.removeStaleChildren("new", 9102)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Expected removeStaleChildren not to wait for the drain, returned after %v", elapsed)
+	}
+	lr.childrenMutex.Lock()
+	_, registered := lr.children[9101]
+	lr.childrenMutex.Unlock()
+	if registered {
+		t.Error("Expected the old child to be unregistered")
+	}
+
+	for i := 0; i < 100 && hasBackend(lr, 9101); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hasBackend(lr, 9101) {
+		t.Fatal("Expected the old backend to be removed from the load balancer")
+	}
+	time.Sleep(100 * time.Millisecond)
+	select {
+	case <-old.exited:
+		t.Fatal("Expected the old child to keep running while a request is in flight")
+	default:
+	}
+	if st := lr.status(); st.Slots[0].ID != "old" || !st.Slots[0].Draining || st.Slots[0].InFlight != 1 {
+		t.Errorf("Expected the old slot to be draining with 1 in-flight request, got %+v", st.Slots[0])
+	}
+
+	lr.inFlight.add(9101, -1)
+	select {
+	case <-old.exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the old child to be stopped after the drain")
+	}
+	for i := 0; i < 100 && lr.status().Slots[0].Draining; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if st := lr.status(); st.Slots[0].Running {
+		t.Errorf("Expected the old slot to be free, got %+v", st.Slots[0])
+	}
+}
+
+// TestRemoveStaleChildren_DrainTimeout tests that an old child is stopped when the drain timeout expires.
+func TestRemoveStaleChildren_DrainTimeout(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t)
+	lr.DrainTimeout = 100 * time.Millisecond
+	old := startSleepChild(t, lr, 9101, "old")
+	lr.inFlight.add(9101, 1)
+
+	start := time.Now()
+	lr.removeStaleChildren("new", 9102)
+	select {
+	case <-old.exited:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the old child to be stopped")
+	}
+	if elapsed := time.Since(start); elapsed < lr.DrainTimeout {
+		t.Errorf("Expected to wait for the drain timeout, stopped after %v", elapsed)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+)
+
+// validateHealthcheckFlags checks the combination of healthcheck transport flags.
+func (liveRoll *LiveRoll) validateHealthcheckFlags() error {
+	switch liveRoll.HealthScheme {
+	case "http", "https":
+	case "unix":
+		if liveRoll.HealthSocket == "" {
+			return fmt.Errorf("--health-socket is required when --health-scheme=unix")
+		}
+	default:
+		return fmt.Errorf("unsupported --health-scheme %q (must be http, https or unix)", liveRoll.HealthScheme)
+	}
+	if (liveRoll.HealthCertFile == "") != (liveRoll.HealthKeyFile == "") {
+		return fmt.Errorf("--health-cert-file and --health-key-file must be specified together")
+	}
+	return nil
+}
+
+// healthURLForPort builds the healthcheck URL of the child process listening on port.
+// For the unix scheme, the host only serves as the Host header; the connection goes to HealthSocket.
+func (liveRoll *LiveRoll) healthURLForPort(port int) string {
+	host := liveRoll.HealthHost
+	if host == "" {
+		host = "localhost"
+	}
+	switch liveRoll.HealthScheme {
+	case "https":
+		return fmt.Sprintf("https://%s%s", net.JoinHostPort(host, strconv.Itoa(port)), liveRoll.HealthcheckPath)
+	case "unix":
+		return fmt.Sprintf("http://%s%s", host, liveRoll.HealthcheckPath)
+	default:
+		return fmt.Sprintf("http://%s%s", net.JoinHostPort(host, strconv.Itoa(port)), liveRoll.HealthcheckPath)
+	}
+}
+
+// newHealthClient creates the HTTP client used to check the health of the child process.
+func (liveRoll *LiveRoll) newHealthClient(child *ChildProcess) (*http.Client, error) {
+	transport := &http.Transport{DisableKeepAlives: true}
+
+	if liveRoll.HealthScheme == "https" {
+		tlsConfig, err := liveRoll.healthTLSConfig()
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = tlsConfig
+	}
+
+	if liveRoll.HealthScheme == "unix" {
+		socketPath := liveRoll.expandTemplate(liveRoll.HealthSocket, child.port, child.id)
+		transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", socketPath)
+		}
+	}
+
+	return &http.Client{Transport: transport}, nil
+}
+
+// healthTLSConfig builds the TLS configuration for HTTPS healthchecks.
+func (liveRoll *LiveRoll) healthTLSConfig() (*tls.Config, error) {
+	tlsConfig := &tls.Config{ServerName: liveRoll.HealthServerName}
+
+	if liveRoll.HealthCAFile != "" {
+		pem, err := os.ReadFile(liveRoll.HealthCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", liveRoll.HealthCAFile)
+		}
+		tlsConfig.RootCAs = pool
+	}
+
+	if liveRoll.HealthCertFile != "" {
+		cert, err := tls.LoadX509KeyPair(liveRoll.HealthCertFile, liveRoll.HealthKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/pem"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestHealthURLForPort tests the healthcheck URL built for each scheme.
+func TestHealthURLForPort(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.HealthcheckPath = "/healthz"
+	lr.HealthHost = "app.internal"
+
+	tests := map[string]string{
+		"http":  "http://app.internal:9101/healthz",
+		"https": "https://app.internal:9101/healthz",
+		"unix":  "http://app.internal/healthz",
+	}
+	for scheme, want := range tests {
+		lr.HealthScheme = scheme
+		if got := lr.healthURLForPort(9101); got != want {
+			t.Errorf("scheme %s: expected %q, got %q", scheme, want, got)
+		}
+	}
+}
+
+// TestWaitForHealth_HTTPS tests a HTTPS healthcheck verified with a CA bundle.
+func TestWaitForHealth_HTTPS(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	caPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ts.Certificate().Raw})
+	if err := os.WriteFile(caFile, caPEM, 0o644); err != nil {
+		t.Fatalf("Failed to write CA file: %v", err)
+	}
+
+	lr := createTestLiveRoll()
+	lr.HealthScheme = "https"
+	child := &ChildProcess{port: 12345, healthURL: ts.URL}
+
+	// Without the CA bundle the certificate can't be verified.
+	lr.HealthTimeout = 300 * time.Millisecond
+	if err := lr.waitForHealth(child); err == nil {
+		t.Error("Expected health check to fail without the CA bundle")
+	}
+
+	lr.HealthTimeout = 2 * time.Second
+	lr.HealthCAFile = caFile
+	lr.HealthServerName = "example.com"
+	if err := lr.waitForHealth(child); err != nil {
+		t.Errorf("Expected health check to succeed, got error: %v", err)
+	}
+}
+
+// TestWaitForHealth_UnixSocket tests a healthcheck over a Unix socket.
+func TestWaitForHealth_UnixSocket(t *testing.T) {
+	dir := t.TempDir()
+	listener, err := net.Listen("unix", filepath.Join(dir, "9101.sock"))
+	if err != nil {
+		t.Fatalf("Failed to listen on unix socket: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/healthz" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})}
+	go func() { _ = server.Serve(listener) }()
+	defer server.Close()
+
+	lr := createTestLiveRoll()
+	lr.HealthScheme = "unix"
+	lr.HealthcheckPath = "/healthz"
+	lr.HealthSocket = filepath.Join(dir, "<<PORT>>.sock")
+	child := &ChildProcess{port: 9101, healthURL: lr.healthURLForPort(9101)}
+
+	if err := lr.waitForHealth(child); err != nil {
+		t.Errorf("Expected health check to succeed, got error: %v", err)
+	}
+}
+
+// TestValidateHealthcheckFlags tests the validation of healthcheck transport flags.
+func TestValidateHealthcheckFlags(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.HealthScheme = "unix"
+	if err := lr.validateHealthcheckFlags(); err == nil {
+		t.Error("Expected error for unix scheme without --health-socket")
+	}
+
+	lr.HealthScheme = "https"
+	lr.HealthCertFile = "cert.pem"
+	if err := lr.validateHealthcheckFlags(); err == nil {
+		t.Error("Expected error for --health-cert-file without --health-key-file")
+	}
+
+	lr.HealthKeyFile = "key.pem"
+	if err := lr.validateHealthcheckFlags(); err != nil {
+		t.Errorf("Expected no error, got: %v", err)
+	}
+}
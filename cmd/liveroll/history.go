@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// maxHistory is the number of rollout results kept in memory for the admin API.
+const maxHistory = 100
+
+const (
+	triggerStartup  = "startup"
+	triggerInterval = "interval"
+	triggerSignal   = "sighup"
+	triggerCrash    = "crash"
+	triggerRollback = "rollback"
+)
+
+// PhaseTiming is the time spent in one phase of an update process.
+type PhaseTiming struct {
+	Name       string `json:"name"`
+	DurationMS int64  `json:"duration_ms"`
+}
+
+// maxHistoryFileSize is the size of history.jsonl above which it is rotated to history.jsonl.1.
+const maxHistoryFileSize = 10 << 20
+
+// historyPath returns the path of the rollout history in stateDir.
+func historyPath(stateDir string) string {
+	return filepath.Join(stateDir, "history.jsonl")
+}
+
+// isNoopOutcome reports whether an update process with outcome changed nothing.
+// Such runs happen on every interval tick and are the first to leave the in-memory history.
+func isNoopOutcome(outcome string) bool {
+	return outcome == outcomeUnchanged || outcome == outcomeSkipped
+}
+
+// trimHistory drops results from history until at most maxHistory remain, the oldest
+// runs that changed nothing first, so that they don't push rollouts out.
+func trimHistory(history []RolloutResult) []RolloutResult {
+	for len(history) > maxHistory {
+		drop := 0
+		for i, result := range history {
+			if isNoopOutcome(result.Outcome) {
+				drop = i
+				break
+			}
+		}
+		history = append(history[:drop:drop], history[drop+1:]...)
+	}
+	return history
+}
+
+// readHistory reads the rollout results stored in stateDir, oldest first,
+// including the rotated history file.
+func readHistory(stateDir string) ([]RolloutResult, error) {
+	var history []RolloutResult
+	for _, path := range []string{historyPath(stateDir) + ".1", historyPath(stateDir)} {
+		results, err := readHistoryFile(path)
+		if err != nil {
+			return nil, err
+		}
+		history = append(history, results...)
+	}
+	return history, nil
+}
+
+// readHistoryFile reads the rollout results in path, which may not exist.
+func readHistoryFile(path string) ([]RolloutResult, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var history []RolloutResult
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var result RolloutResult
+		if err := json.Unmarshal(scanner.Bytes(), &result); err != nil {
+			// A crash may leave a partial last line behind. Skip it rather than refusing to start.
+			log.Printf("Skipping line %d of %s: %v", line, path, err)
+			continue
+		}
+		history = append(history, result)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %v", path, err)
+	}
+	return history, nil
+}
+
+// loadHistory reads the most recent rollout results from the state directory.
+func (liveRoll *LiveRoll) loadHistory() error {
+	if liveRoll.StateDir == "" {
+		return nil
+	}
+	history, err := readHistory(liveRoll.StateDir)
+	if err != nil {
+		return err
+	}
+	liveRoll.rolloutMutex.Lock()
+	liveRoll.history = trimHistory(history)
+	liveRoll.rolloutMutex.Unlock()
+	return nil
+}
+
+// appendHistory adds a completed rollout result to the history and appends it to the history
+// file, rotating the file when it grows over maxHistoryFileSize.
+func (liveRoll *LiveRoll) appendHistory(result RolloutResult) {
+	liveRoll.rolloutMutex.Lock()
+	liveRoll.history = trimHistory(append(liveRoll.history, result))
+	liveRoll.rolloutMutex.Unlock()
+
+	if liveRoll.StateDir == "" {
+		return
+	}
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Failed to encode rollout history: %v", err)
+		return
+	}
+	path := historyPath(liveRoll.StateDir)
+	if info, err := os.Stat(path); err == nil && info.Size()+int64(len(data))+1 > maxHistoryFileSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			log.Printf("Failed to rotate rollout history: %v", err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		log.Printf("Failed to open rollout history: %v", err)
+		return
+	}
+	defer f.Close()
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		log.Printf("Failed to save rollout history: %v", err)
+	}
+}
+
+// rolloutHistory returns the rollout results, oldest first. If id is not empty,
+// only the rollouts from or to that ID are returned.
+func (liveRoll *LiveRoll) rolloutHistory(id string) []RolloutResult {
+	liveRoll.rolloutMutex.Lock()
+	defer liveRoll.rolloutMutex.Unlock()
+	return filterHistory(liveRoll.history, id)
+}
+
+// filterHistory returns the rollout results from or to id, or all of them if id is empty.
+func filterHistory(history []RolloutResult, id string) []RolloutResult {
+	filtered := make([]RolloutResult, 0, len(history))
+	for _, result := range history {
+		if id == "" || result.OldID == id || result.NewID == id {
+			filtered = append(filtered, result)
+		}
+	}
+	return filtered
+}
+
+// recordPhase records the time spent in a phase of the running update process since start.
+func (liveRoll *LiveRoll) recordPhase(name string, start time.Time) {
+	d := time.Since(start)
+	liveRoll.updateRollout(func(r *RolloutResult) {
+		r.Phases = append(r.Phases, PhaseTiming{Name: name, DurationMS: d.Milliseconds()})
+	})
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+// TestRolloutHistory tests that completed update processes are persisted and reloaded.
+func TestRolloutHistory(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.StateDir = t.TempDir()
+
+	result := lr.beginRollout(updateRequest{trigger: triggerInterval})
+	lr.updateRollout(func(r *RolloutResult) { r.NewID = "v1" })
+	lr.recordPhase("pull", result.StartedAt)
+	lr.finishRollout(result, nil)
+
+	lr.currentID = "v1"
+	result = lr.beginRollout(updateRequest{trigger: triggerSignal, forced: true})
+	lr.updateRollout(func(r *RolloutResult) { r.NewID = "v2" })
+	lr.finishRollout(result, errors.New("healthcheck failed"))
+
+	// A partial line left behind by a crash is skipped.
+	f, err := os.OpenFile(historyPath(lr.StateDir), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = f.WriteString(`{"started_at":`)
+	f.Close()
+
+	restored := createTestLiveRoll()
+	restored.StateDir = lr.StateDir
+	if err := restored.loadHistory(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	history := restored.rolloutHistory("")
+	if len(history) != 2 {
+		t.Fatalf("Expected 2 entries, got %+v", history)
+	}
+	if history[0].Trigger != triggerInterval || history[0].Outcome != outcomePromoted ||
+		len(history[0].Phases) != 1 || history[0].Phases[0].Name != "pull" {
+		t.Errorf("Unexpected first entry: %+v", history[0])
+	}
+	if history[1].Trigger != triggerSignal || history[1].Outcome != outcomeFailed ||
+		history[1].OldID != "v1" || history[1].Error != "healthcheck failed" {
+		t.Errorf("Unexpected second entry: %+v", history[1])
+	}
+
+	if filtered := restored.rolloutHistory("v2"); len(filtered) != 1 || filtered[0].NewID != "v2" {
+		t.Errorf("Expected only the rollout to v2, got %+v", filtered)
+	}
+}
+
+// TestRunSubcommand_History tests the history subcommand against the admin API.
+func TestRunSubcommand_History(t *testing.T) {
+	lr := createTestLiveRoll()
+	result := lr.beginRollout(updateRequest{trigger: triggerStartup, forced: true})
+	lr.updateRollout(func(r *RolloutResult) { r.NewID = "v1" })
+	lr.finishRollout(result, nil)
+	socket := startTestAdmin(t, lr)
+
+	var out bytes.Buffer
+	if err := runSubcommand("history", []string{"--admin-socket", socket, "v1"}, &out); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	var history []RolloutResult
+	if err := json.Unmarshal(out.Bytes(), &history); err != nil {
+		t.Fatalf("Failed to decode output %q: %v", out.String(), err)
+	}
+	if len(history) != 1 || history[0].Trigger != triggerStartup {
+		t.Errorf("Unexpected history: %+v", history)
+	}
+}
+
+// TestAppendHistory_Retention tests that runs changing nothing are recorded without pushing
+// rollouts out of the history, and that the history file is rotated and still read by the
+// history subcommand.
+func TestAppendHistory_Retention(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.StateDir = t.TempDir()
+
+	lr.appendHistory(RolloutResult{NewID: "v1", Outcome: outcomePromoted})
+	for i := 0; i < 2*maxHistory; i++ {
+		lr.appendHistory(RolloutResult{OldID: "v1", NewID: "v1", Outcome: outcomeUnchanged})
+	}
+	if history := lr.rolloutHistory(""); len(history) != maxHistory || history[0].Outcome != outcomePromoted ||
+		history[1].Outcome != outcomeUnchanged {
+		t.Fatalf("Expected the promotion and the latest unchanged runs, got %d results starting with %+v",
+			len(history), history[0])
+	}
+	stored, err := readHistory(lr.StateDir)
+	if err != nil {
+		t.Fatalf("Failed to read the history file: %v", err)
+	}
+	if len(stored) != 1+2*maxHistory {
+		t.Errorf("Expected every run in the history file, got %d", len(stored))
+	}
+
+	// Fill the history file until it is rotated.
+	padding := RolloutResult{NewID: "v2", Outcome: outcomeFailed, Error: strings.Repeat("x", 64*1024)}
+	for i := 0; ; i++ {
+		if _, err := os.Stat(historyPath(lr.StateDir) + ".1"); err == nil {
+			break
+		}
+		if i > maxHistoryFileSize/(64*1024)+1 {
+			t.Fatal("Expected the history file to be rotated")
+		}
+		lr.appendHistory(padding)
+	}
+	lr.appendHistory(RolloutResult{OldID: "v1", NewID: "v3", Outcome: outcomePromoted})
+
+	var out bytes.Buffer
+	if err := runSubcommand("history", []string{"--state-dir", lr.StateDir, "v1"}, &out); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	var history []RolloutResult
+	if err := json.Unmarshal(out.Bytes(), &history); err != nil {
+		t.Fatalf("Failed to decode output: %v", err)
+	}
+	if n := len(history); n != 2+2*maxHistory || history[0].Outcome != outcomePromoted || history[n-1].NewID != "v3" {
+		t.Errorf("Expected the rollouts from and to v1 in both files, got %d results", n)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// serveProxy serves handler on ListenPort. With TLS certificates, the certificate is selected
+// by SNI and HTTP/2 is negotiated through ALPN; in cleartext, H2C enables HTTP/2 with prior
+// knowledge or an h2c upgrade.
+func (liveRoll *LiveRoll) serveProxy(handler http.Handler) error {
+	server := &http.Server{
+		Addr:    fmt.Sprintf(":%d", liveRoll.ListenPort),
+		Handler: handler,
+	}
+	tlsConfig, err := liveRoll.newTLSConfig()
+	if err != nil {
+		return err
+	}
+	if tlsConfig != nil {
+		server.TLSConfig = tlsConfig
+		log.Printf("Starting reverse proxy on %s (TLS)", server.Addr)
+		return server.ListenAndServeTLS("", "")
+	}
+	if liveRoll.H2C {
+		server.Handler = h2c.NewHandler(handler, &http2.Server{})
+		log.Printf("Starting reverse proxy on %s (h2c)", server.Addr)
+	} else {
+		log.Printf("Starting reverse proxy on %s", server.Addr)
+	}
+	return server.ListenAndServe()
+}
+
+// newTLSConfig returns the TLS configuration of the listener, or nil to serve cleartext.
+// Certificates come from the certificate files and, for ACMEDomains, from the ACME CA.
+func (liveRoll *LiveRoll) newTLSConfig() (*tls.Config, error) {
+	if len(liveRoll.TLSCertFiles) == 0 && len(liveRoll.ACMEDomains) == 0 {
+		return nil, nil
+	}
+	config := &tls.Config{NextProtos: []string{"h2", "http/1.1"}}
+
+	if len(liveRoll.TLSCertFiles) > 0 {
+		store, err := newCertificateStore(liveRoll.TLSCertFiles, liveRoll.TLSKeyFiles)
+		if err != nil {
+			return nil, err
+		}
+		if liveRoll.TLSReloadInterval > 0 {
+			go store.watch(liveRoll.TLSReloadInterval)
+		}
+		log.Printf("Loaded %d TLS certificate(s)", len(liveRoll.TLSCertFiles))
+		config.GetCertificate = store.getCertificate
+	}
+
+	if len(liveRoll.ACMEDomains) > 0 {
+		manager, err := liveRoll.newACMEManager()
+		if err != nil {
+			return nil, err
+		}
+		if liveRoll.ACMEHTTPPort != 0 {
+			go liveRoll.serveACMEChallenges(manager)
+		}
+		log.Printf("Obtaining certificates for %s from %s", strings.Join(liveRoll.ACMEDomains, ", "), manager.Client.DirectoryURL)
+		config.GetCertificate = liveRoll.acmeGetCertificate(manager, config.GetCertificate)
+		// TLS-ALPN-01 challenges are answered on the listener itself.
+		config.NextProtos = append(config.NextProtos, acme.ALPNProto)
+	}
+	return config, nil
+}
+
+// isGRPC reports whether req is a gRPC call. gRPC responses carry trailers and may stream,
+// so they bypass the response buffer.
+func isGRPC(req *http.Request) bool {
+	return strings.HasPrefix(req.Header.Get("Content-Type"), "application/grpc")
+}
+
+// backendTransport sends requests to the children with cleartext HTTP/2, except upgrade
+// requests, which are only defined for HTTP/1.1.
+type backendTransport struct {
+	h2c   http.RoundTripper
+	http1 http.RoundTripper
+}
+
+func (t *backendTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if isUpgrade(req) {
+		return t.http1.RoundTrip(req)
+	}
+	return t.h2c.RoundTrip(req)
+}
+
+// newBackendTransport returns the transport of the forwarder: HTTP/1.1, or h2c with BackendH2C.
+func (liveRoll *LiveRoll) newBackendTransport() http.RoundTripper {
+	if !liveRoll.BackendH2C {
+		return http.DefaultTransport
+	}
+	return &backendTransport{
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+		http1: http.DefaultTransport,
+	}
+}
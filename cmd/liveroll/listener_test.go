@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// h2cClient returns a client speaking cleartext HTTP/2 with prior knowledge.
+func h2cClient() *http.Client {
+	return &http.Client{
+		Timeout: 5 * time.Second,
+		Transport: &http2.Transport{
+			AllowHTTP: true,
+			DialTLSContext: func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, network, addr)
+			},
+		},
+	}
+}
+
+// TestServeProxy_H2C tests that the listener accepts cleartext HTTP/2 with --h2c.
+func TestServeProxy_H2C(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lr := createTestLiveRoll()
+	lr.ListenPort = l.Addr().(*net.TCPAddr).Port
+	lr.H2C = true
+	l.Close()
+
+	go func() {
+		_ = lr.serveProxy(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.Proto)
+		}))
+	}()
+
+	url := "http://" + l.Addr().String() + "/"
+	var resp *http.Response
+	for i := 0; i < 100; i++ {
+		if resp, err = h2cClient().Get(url); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Failed to connect with h2c: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "HTTP/2.0" {
+		t.Errorf("Expected the request to be served over HTTP/2, got %q", body)
+	}
+}
+
+// TestBackendH2C tests that gRPC calls reach the children over h2c with their trailers.
+func TestBackendH2C(t *testing.T) {
+	backend := httptest.NewServer(h2c.NewHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 {
+			t.Errorf("Expected HTTP/2 to the backend, got %s", r.Proto)
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		_, _ = io.WriteString(w, "reply")
+		w.(http.Flusher).Flush()
+		w.Header().Set("Grpc-Status", "0")
+	}), &http2.Server{}))
+	defer backend.Close()
+
+	lr := createTestLiveRoll()
+	lr.BackendH2C = true
+	_, proxyURL := createTestProxy(t, lr, backend)
+
+	req, _ := http.NewRequest(http.MethodPost, proxyURL+"/pkg.Service/Method", strings.NewReader("call"))
+	req.Header.Set("Content-Type", "application/grpc")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "reply" || resp.Trailer.Get("Grpc-Status") != "0" {
+		t.Errorf("Expected the reply with its trailer, got %q %v", body, resp.Trailer)
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// webSocketCloseFrame is a WebSocket close frame with status 1001 (going away).
+var webSocketCloseFrame = []byte{0x88, 0x02, 0x03, 0xE9}
+
+// isUpgrade reports whether req asks to upgrade the connection, e.g. to a WebSocket.
+func isUpgrade(req *http.Request) bool {
+	return req.Header.Get("Upgrade") != "" &&
+		strings.Contains(strings.ToLower(req.Header.Get("Connection")), "upgrade")
+}
+
+// isEventStream reports whether req subscribes to server-sent events.
+func isEventStream(req *http.Request) bool {
+	return strings.Contains(req.Header.Get("Accept"), "text/event-stream")
+}
+
+// isLongLived reports whether req is expected to hold its connection open, i.e. an upgrade
+// or a server-sent events subscription. Such requests bypass the response buffer.
+func isLongLived(req *http.Request) bool {
+	return isUpgrade(req) || isEventStream(req)
+}
+
+// longLivedConn is an upgraded connection or a server-sent events stream proxied to a backend.
+type longLivedConn struct {
+	port      int
+	websocket bool
+	cancel    context.CancelFunc
+
+	mutex sync.Mutex
+	// client connection, set when the connection is upgraded
+	conn net.Conn
+}
+
+func (c *longLivedConn) setConn(conn net.Conn) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.conn = conn
+}
+
+// close terminates the connection. For WebSockets, a close frame is sent first if sendCloseFrame is true.
+func (c *longLivedConn) close(sendCloseFrame bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.conn != nil {
+		if c.websocket && sendCloseFrame {
+			// The frame may interleave with a message being copied from the backend,
+			// which is acceptable as the connection is closed right after.
+			_ = c.conn.SetWriteDeadline(time.Now().Add(time.Second))
+			_, _ = c.conn.Write(webSocketCloseFrame)
+		}
+		_ = c.conn.Close()
+	}
+	c.cancel()
+}
+
+// longLivedConns tracks the long-lived connections per backend port.
+type longLivedConns struct {
+	mutex sync.Mutex
+	conns map[*longLivedConn]struct{}
+}
+
+func newLongLivedConns() *longLivedConns {
+	return &longLivedConns{conns: make(map[*longLivedConn]struct{})}
+}
+
+func (l *longLivedConns) add(c *longLivedConn) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.conns[c] = struct{}{}
+}
+
+func (l *longLivedConns) remove(c *longLivedConn) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.conns, c)
+}
+
+// forPort returns the long-lived connections to port.
+func (l *longLivedConns) forPort(port int) []*longLivedConn {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	var conns []*longLivedConn
+	for c := range l.conns {
+		if c.port == port {
+			conns = append(conns, c)
+		}
+	}
+	return conns
+}
+
+// count returns the number of long-lived connections to port.
+func (l *longLivedConns) count(port int) int {
+	return len(l.forPort(port))
+}
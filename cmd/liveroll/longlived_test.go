@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vulcand/oxy/v2/forward"
+	"github.com/vulcand/oxy/v2/roundrobin"
+)
+
+// createTestProxy builds the proxy stack of Run for lr in front of backend and returns its URL.
+func createTestProxy(t *testing.T, lr *LiveRoll, backend *httptest.Server) (*ChildProcess, string) {
+	t.Helper()
+	fwd := forward.New(false)
+	fwd.ErrorHandler = proxyErrorHandler
+	fwd.Transport = lr.newBackendTransport()
+	lr.forwarder = lr.observeBackend(fwd)
+	var err error
+	if lr.lb, err = roundrobin.New(lr.avoidTriedBackends(lr.forwarder), lr.loadBalancerOptions()...); err != nil {
+		t.Fatal(err)
+	}
+	bufferHandler, err := lr.newBufferHandler(lr.lb)
+	if err != nil {
+		t.Fatal(err)
+	}
+	proxy := httptest.NewServer(lr.withStreaming(bufferHandler))
+	t.Cleanup(proxy.Close)
+
+	child := childForServer(t, backend)
+	child.exited = make(chan struct{})
+	lr.addBackend(child)
+	return child, proxy.URL
+}
+
+// TestLongLived_WebSocket tests that upgraded connections are proxied, tracked and closed
+// with a close frame when their backend is drained.
+func TestLongLived_WebSocket(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			t.Errorf("Failed to hijack: %v", err)
+			return
+		}
+		defer conn.Close()
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+		_ = rw.Flush()
+		_, _ = io.Copy(io.Discard, rw)
+	}))
+	defer backend.Close()
+	lr := createTestLiveRoll()
+	child, proxyURL := createTestProxy(t, lr, backend)
+	lr.LongLivedDrainTimeout = 100 * time.Millisecond
+	lr.WebSocketCloseFrame = true
+
+	conn, err := net.Dial("tcp", strings.TrimPrefix(proxyURL, "http://"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	fmt.Fprintf(conn, "GET /ws HTTP/1.1\r\nHost: localhost\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n\r\n")
+	br := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(br, nil)
+	if err != nil || resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("Expected 101, got %v (%v)", resp, err)
+	}
+	if n := lr.longLived.count(child.port); n != 1 {
+		t.Fatalf("Expected 1 long-lived connection, got %d", n)
+	}
+
+	lr.drainAndStop([]*ChildProcess{child})
+
+	_ = conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	rest, _ := io.ReadAll(br)
+	if !bytes.Equal(rest, webSocketCloseFrame) {
+		t.Errorf("Expected a close frame before the connection is closed, got %x", rest)
+	}
+	if n := lr.longLived.count(child.port); n != 0 {
+		t.Errorf("Expected no long-lived connection after the drain, got %d", n)
+	}
+}
+
+// TestLongLived_EventStream tests that event streams bypass the response buffer and are
+// closed when their backend is drained.
+func TestLongLived_EventStream(t *testing.T) {
+	backend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		_, _ = io.WriteString(w, "data: hello\n\n")
+		w.(http.Flusher).Flush()
+		<-r.Context().Done()
+	}))
+	defer backend.Close()
+	lr := createTestLiveRoll()
+	child, proxyURL := createTestProxy(t, lr, backend)
+	lr.LongLivedDrainTimeout = 100 * time.Millisecond
+
+	req, _ := http.NewRequest(http.MethodGet, proxyURL+"/events", nil)
+	req.Header.Set("Accept", "text/event-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+	if err != nil || line != "data: hello\n" {
+		t.Fatalf("Expected the first event without buffering, got %q (%v)", line, err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		_, _ = io.Copy(io.Discard, resp.Body)
+		close(done)
+	}()
+	lr.drainAndStop([]*ChildProcess{child})
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Error("Expected the event stream to be closed by the drain")
+	}
+}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"github.com/vulcand/oxy/v2/buffer"
 	"github.com/vulcand/oxy/v2/forward"
 	"github.com/vulcand/oxy/v2/roundrobin"
+	"golang.org/x/crypto/acme/autocert"
 	"io"
 	"log"
 	"net/http"
@@ -16,6 +18,7 @@ import (
 	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 )
@@ -30,23 +33,195 @@ type LiveRoll struct {
 	ChildPort1      int
 	ChildPort2      int
 	HealthTimeout   time.Duration
+	// per-attempt healthcheck settings
+	HealthAttemptTimeout time.Duration
+	HealthInterval       time.Duration
+	HealthMaxInterval    time.Duration
+	HealthBackoff        float64
+	// healthcheck transport
+	HealthScheme     string
+	HealthHost       string
+	HealthSocket     string
+	HealthCAFile     string
+	HealthCertFile   string
+	HealthKeyFile    string
+	HealthServerName string
+
+	// HTTP/2 and TLS on the listener, and h2c to the children
+	TLSCertFiles      []string
+	TLSKeyFiles       []string
+	TLSReloadInterval time.Duration
+	H2C               bool
+	BackendH2C        bool
+
+	// certificates obtained through ACME
+	ACMEDomains      []string
+	ACMEEmail        string
+	ACMEDirectoryURL string
+	ACMECAFile       string
+	ACMEHTTPPort     int
+
+	// buffering of request and response bodies, bypassed by streaming requests
+	BufferMaxRequestBody  int64
+	BufferMemRequestBody  int64
+	BufferMaxResponseBody int64
+	BufferMemResponseBody int64
+	StreamingPaths        []string
+	StreamingContentTypes []string
+
+	// retries of buffered requests
+	RetryAttempts       int
+	RetryIdempotentOnly bool
+	RetryStatuses       []int
+
+	// warmup requests replayed against a new child before it receives traffic
+	WarmupFile           string
+	WarmupTimeout        time.Duration
+	WarmupRequireSuccess bool
+
+	// command run against a new child before it receives traffic
+	SmokeTestCmdStr  string
+	SmokeTestTimeout time.Duration
+
+	// weighted canary rollout
+	CanarySteps []int
+	CanaryBake  time.Duration
+	// bake period for non-canary rollouts
+	BakeTime time.Duration
+
+	// automatic rollback on regression during bake periods
+	RollbackErrorRateIncrease float64
+	RollbackLatencyRatio      float64
+	RollbackLatencyPercentile float64
+	RollbackMinRequests       int
+
+	// blue/green rollouts with manual promotion
+	ManualPromotion  bool
+	PromotionTimeout time.Duration
+	PreviewPort      int
+
+	// shadow traffic mirrored to the candidate
+	ShadowPercent  float64
+	ShadowDuration time.Duration
+	ShadowTimeout  time.Duration
+	ShadowMaxBody  int64
+	// mirror requests with methods other than GET, HEAD and OPTIONS
+	ShadowUnsafeMethods bool
+
+	// when interval-triggered updates may promote a new ID
+	RolloutWindows   []rolloutWindow
+	RolloutBlackouts []rolloutWindow
+	RolloutLocation  *time.Location
+
+	// requests held while no backend is available
+	QueueTimeout time.Duration
+	QueueSize    int
+
+	// how long an old child may finish its in-flight requests before it is stopped
+	DrainTimeout time.Duration
+	// how long WebSockets and event streams to an old child may stay open before they are closed
+	LongLivedDrainTimeout time.Duration
+	WebSocketCloseFrame   bool
+
+	// session affinity, and how long sticky clients stay on an old child during a rollout
+	StickyCookie       string
+	StickyDrainTimeout time.Duration
+	StickyIdleTimeout  time.Duration
+	// sticky session of the load balancer, nil if StickyCookie is empty
+	stickySession *roundrobin.StickySession
+
+	// how long interval updates skip an ID that failed to roll out
+	QuarantineDuration time.Duration
+
+	// routing of requests to a specific child process
+	RouteHeader       string
+	RouteCookie       string
+	RouteNewestHeader string
+	RouteNewestCookie string
+
+	// admin API for the subcommands
+	AdminSocket string
+
+	// passive health checking of proxied traffic
+	OutlierErrorRate          float64
+	OutlierMinRequests        int
+	OutlierInterval           time.Duration
+	OutlierEjectionDuration   time.Duration
+	OutlierMaxEjectionPercent int
+
+	// liveroll's own status endpoint
+	StatusPath string
+	StatusPort int
+
+	// directory where liveroll keeps its state across restarts
+	StateDir string
+	// run the pull and id commands while pinned to report the newly available ID
+	CheckUpdatesWhilePinned bool
 
 	// current image ID (output from the --id command)
 	currentID      string
 	currentIDMutex sync.Mutex
+	// successfully deployed IDs, oldest first
+	deployments []Deployment
+	// ID that updates are pinned to (e.g. after a rollback)
+	pinned string
+	// newer ID found by the id command while pinned
+	availableID string
+	// newer ID found by an interval update outside the rollout windows
+	deferredID string
+	// IDs that failed to roll out, skipped by interval updates
+	quarantine map[string]QuarantinedID
 
 	// Manage child processes (key: assigned child process port)
 	children      map[int]*ChildProcess
 	childrenMutex sync.Mutex
+	// old child processes removed from children and waiting for in-flight requests (key: port)
+	draining map[int]*ChildProcess
+
+	// New child process held out of the load balancer until it is promoted
+	candidate     *ChildProcess
+	promotionChan chan bool
+	// comparison of the candidate's responses to mirrored requests
+	shadow *shadowStats
+	// candidate receiving mirrored requests, read by the proxy without childrenMutex
+	shadowTarget atomic.Pointer[ChildProcess]
 
 	// Reverse proxy using oxy round-robin load balancer
 	lb *roundrobin.RoundRobin
+	// Forwarder used by the load balancer, also used to reach specific children
+	forwarder http.Handler
 	// Backend URLs management (key: child process port)
 	backendURLs      map[int]*url.URL
 	backendURLsMutex sync.Mutex
-
-	updateChan        chan bool
-	inShutdownProcess bool
+	// Load balancer weights (key: child process port)
+	backendWeights map[int]int
+	// Backends temporarily ejected by passive health checking (key: backend URL, value: ejected until)
+	ejected  map[string]time.Time
+	outliers *outlierDetector
+	// closed and replaced when a backend starts receiving requests
+	backendsChanged chan struct{}
+	// number of requests waiting for a backend
+	queued atomic.Int64
+
+	// proxied traffic statistics used for regression checks
+	traffic *trafficMetrics
+	// requests being proxied to each backend, waited for before an old child is stopped
+	inFlight *inFlightRequests
+	// upgraded connections and event streams proxied to each backend
+	longLived *longLivedConns
+
+	// result of the running and the last completed update process, and the recent history
+	rollout      *RolloutResult
+	lastRollout  *RolloutResult
+	history      []RolloutResult
+	rolloutMutex sync.Mutex
+
+	// holds at most one pending update request; updateRequestMutex serializes merging into it
+	updateChan         chan updateRequest
+	updateRequestMutex sync.Mutex
+	inShutdownProcess  bool
+	// set once the first child process has been registered with the reverse proxy
+	startupDone atomic.Bool
 }
 
 // ChildProcess represents a launched child process.
@@ -54,19 +229,38 @@ type ChildProcess struct {
 	port      int
 	id        string // output from the --id command
 	cmd       *exec.Cmd
-	healthURL string // e.g., "http://localhost:<port><HealthcheckPath>"
+	healthURL string // e.g., "http://localhost:<port><HealthcheckPath>", see healthURLForPort
+	exited    chan struct{}
+	startedAt time.Time
 }
 
 func NewLiveRoll() LiveRoll {
 	return LiveRoll{
 		children:          make(map[int]*ChildProcess),
 		backendURLs:       make(map[int]*url.URL),
-		updateChan:        make(chan bool, 1),
+		backendWeights:    make(map[int]int),
+		quarantine:        make(map[string]QuarantinedID),
+		draining:          make(map[int]*ChildProcess),
+		inFlight:          newInFlightRequests(),
+		longLived:         newLongLivedConns(),
+		backendsChanged:   make(chan struct{}),
+		ejected:           make(map[string]time.Time),
+		outliers:          newOutlierDetector(),
+		traffic:           newTrafficMetrics(),
+		shadow:            &shadowStats{},
+		updateChan:        make(chan updateRequest, 1),
 		inShutdownProcess: false,
 	}
 }
 
 func main() {
+	if isSubcommand(os.Args[1:]) {
+		if err := runSubcommand(os.Args[1], os.Args[2:], os.Stdout); err != nil {
+			exitWithError(err)
+		}
+		return
+	}
+
 	liveRoll := NewLiveRoll()
 
 	// Define flags
@@ -76,14 +270,165 @@ func main() {
 	flag.DurationVar(&liveRoll.Interval, "Interval", 60*time.Second, "Interval between update checks")
 	flag.StringVar(&liveRoll.HealthcheckPath, "healthcheck", "/heathz", "Path for the healthcheck endpoint")
 	flag.IntVar(&liveRoll.ListenPort, "port", 8080, "Port on which the reverse proxy listens")
+	flag.Func("tls-cert", "Certificate file to serve TLS with HTTP/2 on the listen port (repeatable, selected by SNI, each requires a --tls-key)", func(s string) error {
+		liveRoll.TLSCertFiles = append(liveRoll.TLSCertFiles, s)
+		return nil
+	})
+	flag.Func("tls-key", "Private key file of the --tls-cert at the same position (repeatable)", func(s string) error {
+		liveRoll.TLSKeyFiles = append(liveRoll.TLSKeyFiles, s)
+		return nil
+	})
+	flag.DurationVar(&liveRoll.TLSReloadInterval, "tls-reload-interval", 1*time.Minute, "Interval at which the TLS certificate files are checked for changes and reloaded (0 disables)")
+	flag.Int64Var(&liveRoll.BufferMaxRequestBody, "buffer-max-request-body", 0, "Maximum size of a buffered request body in bytes, larger requests get 413 (0 is unlimited)")
+	flag.Int64Var(&liveRoll.BufferMemRequestBody, "buffer-mem-request-body", 1<<20, "Size of a request body buffered in memory in bytes, the rest is buffered in a temporary file")
+	flag.Int64Var(&liveRoll.BufferMaxResponseBody, "buffer-max-response-body", 0, "Maximum size of a buffered response body in bytes, larger responses get 500 (0 is unlimited)")
+	flag.Int64Var(&liveRoll.BufferMemResponseBody, "buffer-mem-response-body", 1<<20, "Size of a response body buffered in memory in bytes, the rest is buffered in a temporary file")
+	flag.IntVar(&liveRoll.RetryAttempts, "retry-attempts", 2, "Maximum number of attempts of a buffered request, each on another backend if possible (1 disables retries, at most 10)")
+	flag.BoolVar(&liveRoll.RetryIdempotentOnly, "retry-idempotent-only", true, "Only retry requests with an idempotent method (GET, HEAD, OPTIONS, TRACE, PUT, DELETE) or an Idempotency-Key header")
+	flag.Func("retry-status", "Comma separated 5xx status codes retried in addition to network errors (502, 504), e.g. \"503\"", func(s string) error {
+		codes, err := parseRetryStatuses(s)
+		liveRoll.RetryStatuses = codes
+		return err
+	})
+	flag.Func("streaming-path", "Path prefix of requests streamed without buffering, e.g. \"/upload\" (repeatable, \"/\" streams everything)", func(s string) error {
+		liveRoll.StreamingPaths = append(liveRoll.StreamingPaths, s)
+		return nil
+	})
+	flag.Func("streaming-content-type", "Content type of requests (Content-Type) or responses (Accept) streamed without buffering, e.g. \"video/\" (repeatable)", func(s string) error {
+		liveRoll.StreamingContentTypes = append(liveRoll.StreamingContentTypes, strings.ToLower(s))
+		return nil
+	})
+	flag.Func("acme-domain", "Domain whose certificate is obtained and renewed through ACME (repeatable, requires --state-dir)", func(s string) error {
+		liveRoll.ACMEDomains = append(liveRoll.ACMEDomains, strings.ToLower(s))
+		return nil
+	})
+	flag.StringVar(&liveRoll.ACMEEmail, "acme-email", "", "Contact email of the ACME account")
+	flag.StringVar(&liveRoll.ACMEDirectoryURL, "acme-directory-url", autocert.DefaultACMEDirectory, "Directory URL of the ACME CA")
+	flag.StringVar(&liveRoll.ACMECAFile, "acme-ca-file", "", "CA certificate trusted when connecting to the ACME directory, e.g. of a local test server")
+	flag.IntVar(&liveRoll.ACMEHTTPPort, "acme-http-port", 80, "Port answering ACME HTTP-01 challenges and redirecting other requests to HTTPS (0 disables)")
+	flag.BoolVar(&liveRoll.H2C, "h2c", false, "Accept cleartext HTTP/2 (h2c) on the listen port")
+	flag.BoolVar(&liveRoll.BackendH2C, "backend-h2c", false, "Forward requests to the child processes with cleartext HTTP/2 (h2c)")
 	flag.IntVar(&liveRoll.ChildPort1, "child-port1", 9101, "Child process listen port 1")
 	flag.IntVar(&liveRoll.ChildPort2, "child-port2", 9102, "Child process listen port 2")
 	flag.DurationVar(&liveRoll.HealthTimeout, "health-timeout", 30*time.Second, "Healthcheck timeout")
+	flag.DurationVar(&liveRoll.HealthAttemptTimeout, "health-attempt-timeout", 5*time.Second, "Timeout for each healthcheck request")
+	flag.DurationVar(&liveRoll.HealthInterval, "health-interval", 1*time.Second, "Interval between healthcheck attempts")
+	flag.DurationVar(&liveRoll.HealthMaxInterval, "health-max-interval", 10*time.Second, "Upper bound of the healthcheck interval when backoff is enabled")
+	flag.Float64Var(&liveRoll.HealthBackoff, "health-backoff", 1.0, "Multiplier applied to the healthcheck interval after each failed attempt (1 disables backoff)")
+	flag.StringVar(&liveRoll.HealthScheme, "health-scheme", "http", "Scheme used for the healthcheck: http, https or unix")
+	flag.StringVar(&liveRoll.HealthHost, "health-host", "localhost", "Host used in the healthcheck URL")
+	flag.StringVar(&liveRoll.HealthSocket, "health-socket", "", "Unix socket path used when --health-scheme=unix (supports template variables)")
+	flag.StringVar(&liveRoll.HealthCAFile, "health-ca-file", "", "CA bundle used to verify the child's certificate for HTTPS healthchecks")
+	flag.StringVar(&liveRoll.HealthCertFile, "health-cert-file", "", "Client certificate for HTTPS healthchecks")
+	flag.StringVar(&liveRoll.HealthKeyFile, "health-key-file", "", "Client certificate key for HTTPS healthchecks")
+	flag.StringVar(&liveRoll.HealthServerName, "health-server-name", "", "Server name (SNI) used for HTTPS healthchecks")
+	flag.StringVar(&liveRoll.SmokeTestCmdStr, "smoke-test", "", "Command run against a new child after the healthcheck; a non-zero exit aborts the update (supports template variables)")
+	flag.DurationVar(&liveRoll.SmokeTestTimeout, "smoke-test-timeout", 60*time.Second, "Timeout for the smoke test command")
+	flag.StringVar(&liveRoll.WarmupFile, "warmup-file", "", "JSON file listing requests to replay against a new child before it receives traffic")
+	flag.DurationVar(&liveRoll.WarmupTimeout, "warmup-timeout", 10*time.Second, "Timeout for each warmup request")
+	flag.BoolVar(&liveRoll.WarmupRequireSuccess, "warmup-require-success", false, "Abort the update if any warmup request fails")
+	flag.Func("canary-steps", "Comma separated percentages of traffic shifted to a new child, e.g. \"5,25,50,100\" (empty disables canary rollouts)", func(s string) error {
+		steps, err := parseCanarySteps(s)
+		liveRoll.CanarySteps = steps
+		return err
+	})
+	flag.DurationVar(&liveRoll.CanaryBake, "canary-bake", 30*time.Second, "Bake time at each canary step")
+	flag.DurationVar(&liveRoll.BakeTime, "bake-time", 0, "Time both the new and old children serve traffic before the old one is terminated (without --canary-steps)")
+	flag.Float64Var(&liveRoll.RollbackErrorRateIncrease, "rollback-error-rate-increase", 0, "Roll back when the new version's 5xx rate exceeds the old one's by more than this during bake (0 disables)")
+	flag.Float64Var(&liveRoll.RollbackLatencyRatio, "rollback-latency-ratio", 0, "Roll back when the new version's latency percentile exceeds the old one's by this factor during bake (0 disables)")
+	flag.Float64Var(&liveRoll.RollbackLatencyPercentile, "rollback-latency-percentile", 99, "Latency percentile compared by --rollback-latency-ratio")
+	flag.IntVar(&liveRoll.RollbackMinRequests, "rollback-min-requests", 20, "Minimum number of requests to each version before a rollback decision is made")
+	flag.BoolVar(&liveRoll.ManualPromotion, "manual-promotion", false, "Hold a new child out of the load balancer until it is promoted with 'liveroll promote'")
+	flag.DurationVar(&liveRoll.PromotionTimeout, "promotion-timeout", 1*time.Hour, "Abort a candidate that is neither promoted nor aborted within this time (0 waits forever)")
+	flag.IntVar(&liveRoll.PreviewPort, "preview-port", 0, "Port forwarding to the candidate waiting for promotion (0 disables)")
+	flag.Float64Var(&liveRoll.ShadowPercent, "shadow-percent", 0, "Percentage of requests mirrored to the candidate before promotion (0 disables)")
+	flag.DurationVar(&liveRoll.ShadowDuration, "shadow-duration", 0, "Time a new child receives only mirrored requests before it is promoted (0 shadows only while waiting for manual promotion)")
+	flag.DurationVar(&liveRoll.ShadowTimeout, "shadow-timeout", 10*time.Second, "Timeout for mirrored requests")
+	flag.Int64Var(&liveRoll.ShadowMaxBody, "shadow-max-body", 1<<20, "Requests with a larger body are not mirrored")
+	flag.BoolVar(&liveRoll.ShadowUnsafeMethods, "shadow-unsafe-methods", false, "Also mirror requests with methods other than GET, HEAD and OPTIONS, which run writes twice on shared databases and services")
+	flag.Func("rollout-window", "Weekly window in which interval updates may promote a new ID, e.g. \"Mon-Fri 09:00-17:00\" (repeatable, default always)", func(s string) error {
+		w, err := parseRolloutWindow(s)
+		liveRoll.RolloutWindows = append(liveRoll.RolloutWindows, w)
+		return err
+	})
+	flag.Func("rollout-blackout", "Weekly window in which interval updates never promote a new ID, e.g. \"Sat,Sun 10:00-14:00\" (repeatable)", func(s string) error {
+		w, err := parseRolloutWindow(s)
+		liveRoll.RolloutBlackouts = append(liveRoll.RolloutBlackouts, w)
+		return err
+	})
+	flag.Func("rollout-timezone", "Time zone of --rollout-window and --rollout-blackout, e.g. \"Asia/Tokyo\" (default local time)", func(s string) error {
+		loc, err := time.LoadLocation(s)
+		liveRoll.RolloutLocation = loc
+		return err
+	})
+	flag.DurationVar(&liveRoll.QueueTimeout, "queue-timeout", 0, "Time a request is held while no backend is available before 503 is returned (0 disables)")
+	flag.IntVar(&liveRoll.QueueSize, "queue-size", 100, "Maximum number of requests held while no backend is available")
+	flag.DurationVar(&liveRoll.DrainTimeout, "drain-timeout", 30*time.Second, "Time an old child may finish its in-flight requests after it is removed from the load balancer")
+	flag.DurationVar(&liveRoll.LongLivedDrainTimeout, "long-lived-drain-timeout", 30*time.Second, "Time WebSockets and event streams to an old child may stay open after it is removed from the load balancer")
+	flag.StringVar(&liveRoll.StickyCookie, "sticky-cookie", "", "Cookie keeping each client on the same child process (empty disables sticky sessions)")
+	flag.DurationVar(&liveRoll.StickyDrainTimeout, "sticky-drain-timeout", 5*time.Minute, "Time sticky clients stay on an old child during a rollout before they move to the new one (0 moves them immediately)")
+	flag.DurationVar(&liveRoll.StickyIdleTimeout, "sticky-idle-timeout", 10*time.Second, "End the sticky drain of an old child early once it had no request for this time")
+	flag.BoolVar(&liveRoll.WebSocketCloseFrame, "websocket-close-frame", false, "Send a close frame (1001 going away) to WebSocket clients before closing their connection to an old child")
+	flag.DurationVar(&liveRoll.QuarantineDuration, "quarantine-duration", 1*time.Hour, "Time interval updates skip an ID that failed to roll out (0 disables)")
+	flag.StringVar(&liveRoll.RouteHeader, "route-header", "", "Request header naming the ID of the child process to route to (empty disables)")
+	flag.StringVar(&liveRoll.RouteCookie, "route-cookie", "", "Cookie naming the ID of the child process to route to (empty disables)")
+	flag.StringVar(&liveRoll.RouteNewestHeader, "route-newest-header", "", "Request header routing to the newest child process when set to any value (empty disables)")
+	flag.StringVar(&liveRoll.RouteNewestCookie, "route-newest-cookie", "", "Cookie routing to the newest child process when set to any value (empty disables)")
+	flag.StringVar(&liveRoll.StateDir, "state-dir", "", "Directory where liveroll keeps its state across restarts (empty keeps it in memory)")
+	flag.BoolVar(&liveRoll.CheckUpdatesWhilePinned, "check-updates-while-pinned", true, "Run --pull and --id while pinned to report the newly available ID without promoting it")
+	flag.StringVar(&liveRoll.AdminSocket, "admin-socket", "", "Unix socket serving the admin API used by the subcommands (empty disables)")
+	flag.Float64Var(&liveRoll.OutlierErrorRate, "outlier-error-rate", 0, "Eject a backend when this ratio of proxied requests fail with a network error or 5xx (0 disables)")
+	flag.IntVar(&liveRoll.OutlierMinRequests, "outlier-min-requests", 20, "Minimum number of requests in the window before a backend can be ejected")
+	flag.DurationVar(&liveRoll.OutlierInterval, "outlier-interval", 10*time.Second, "Window in which proxied request errors are counted")
+	flag.DurationVar(&liveRoll.OutlierEjectionDuration, "outlier-ejection-duration", 30*time.Second, "How long an ejected backend is kept out of the load balancer")
+	flag.IntVar(&liveRoll.OutlierMaxEjectionPercent, "outlier-max-ejection-percent", 50, "Maximum percentage of backends that can be ejected at the same time")
+	flag.StringVar(&liveRoll.StatusPath, "status-path", "", "Path on the listen port answered by liveroll itself with its aggregate health (empty disables)")
+	flag.IntVar(&liveRoll.StatusPort, "status-port", 0, "Separate port serving liveroll's aggregate health (0 disables)")
+	flag.Usage = printUsage
 	flag.Parse()
 
 	if liveRoll.PullCmdStr == "" || liveRoll.IdCmdStr == "" || liveRoll.ExecCmdStr == "" {
 		log.Fatal("Required flags --pull, --id, and --exec must be specified")
 	}
+	if liveRoll.ManualPromotion && liveRoll.AdminSocket == "" {
+		log.Fatal("--manual-promotion requires --admin-socket")
+	}
+	if err := liveRoll.validateHealthcheckFlags(); err != nil {
+		log.Fatal(err)
+	}
+	if len(liveRoll.TLSCertFiles) != len(liveRoll.TLSKeyFiles) {
+		log.Fatal("every --tls-cert must be paired with a --tls-key")
+	}
+	if liveRoll.RetryAttempts < 1 {
+		log.Fatal("--retry-attempts must be at least 1")
+	}
+	if liveRoll.RetryAttempts > buffer.DefaultMaxRetryAttempts {
+		log.Printf("--retry-attempts %d exceeds the limit of the buffer handler, using %d",
+			liveRoll.RetryAttempts, buffer.DefaultMaxRetryAttempts)
+		liveRoll.RetryAttempts = buffer.DefaultMaxRetryAttempts
+	}
+	if len(liveRoll.ACMEDomains) > 0 && liveRoll.StateDir == "" {
+		log.Fatal("--acme-domain requires --state-dir to store the certificates")
+	}
+	if liveRoll.StateDir != "" {
+		if err := os.MkdirAll(liveRoll.StateDir, 0o755); err != nil {
+			log.Fatalf("Failed to create --state-dir: %v", err)
+		}
+		if err := liveRoll.loadDeployments(); err != nil {
+			log.Fatalf("Failed to load deployment history: %v", err)
+		}
+		if err := liveRoll.loadPin(); err != nil {
+			log.Fatalf("Failed to load pin: %v", err)
+		}
+		if err := liveRoll.loadHistory(); err != nil {
+			log.Fatalf("Failed to load rollout history: %v", err)
+		}
+	}
+	if liveRoll.WarmupFile != "" {
+		if _, err := loadWarmupRequests(liveRoll.WarmupFile); err != nil {
+			log.Fatalf("Invalid --warmup-file: %v", err)
+		}
+	}
 
 	liveRoll.Run()
 }
@@ -91,12 +436,15 @@ func main() {
 func (liveRoll *LiveRoll) Run() {
 	// Initialize the oxy round-robin proxy
 	fwd := forward.New(false)
+	fwd.ErrorHandler = proxyErrorHandler
+	fwd.Transport = liveRoll.newBackendTransport()
+	liveRoll.forwarder = liveRoll.observeBackend(fwd)
 	var err error
-	liveRoll.lb, err = roundrobin.New(fwd)
+	liveRoll.lb, err = roundrobin.New(liveRoll.avoidTriedBackends(liveRoll.forwarder), liveRoll.loadBalancerOptions()...)
 	if err != nil {
 		log.Fatalf("Failed to create roundrobin proxy: %v", err)
 	}
-	bufferHandler, err := buffer.New(liveRoll.lb, buffer.Retry(`IsNetworkError() && Attempts() < 2`))
+	bufferHandler, err := liveRoll.newBufferHandler(liveRoll.lb)
 	if err != nil {
 		log.Fatalf("Failed to create buffer handler: %v", err)
 	}
@@ -108,17 +456,58 @@ func (liveRoll *LiveRoll) Run() {
 	// update process loop
 	go liveRoll.updateLoop()
 
+	var handler http.Handler = liveRoll.withStreaming(bufferHandler)
+	if liveRoll.QueueTimeout > 0 {
+		handler = liveRoll.withQueue(handler)
+	}
+	if liveRoll.ShadowPercent > 0 {
+		handler = liveRoll.withShadow(handler)
+	}
+	if liveRoll.RouteHeader != "" || liveRoll.RouteCookie != "" || liveRoll.RouteNewestHeader != "" || liveRoll.RouteNewestCookie != "" {
+		handler = liveRoll.withRouting(handler)
+	}
+	if liveRoll.StatusPath != "" {
+		handler = liveRoll.withStatusEndpoint(handler)
+	}
+
 	// Start the reverse proxy HTTP server
 	go func() {
-		addr := fmt.Sprintf(":%d", liveRoll.ListenPort)
-		log.Printf("Starting reverse proxy on %s", addr)
-		if err := http.ListenAndServe(addr, bufferHandler); err != nil {
+		if err := liveRoll.serveProxy(handler); err != nil {
 			log.Fatalf("Reverse proxy server terminated: %v", err)
 		}
 	}()
 
+	// Start the preview HTTP server
+	if liveRoll.PreviewPort != 0 {
+		go func() {
+			addr := fmt.Sprintf(":%d", liveRoll.PreviewPort)
+			log.Printf("Starting preview proxy on %s", addr)
+			if err := http.ListenAndServe(addr, http.HandlerFunc(liveRoll.previewHandler)); err != nil {
+				log.Fatalf("Preview server terminated: %v", err)
+			}
+		}()
+	}
+
+	// Start the admin API
+	if liveRoll.AdminSocket != "" {
+		if err := liveRoll.serveAdmin(); err != nil {
+			log.Fatalf("Failed to start admin API: %v", err)
+		}
+	}
+
+	// Start the status HTTP server
+	if liveRoll.StatusPort != 0 {
+		go func() {
+			addr := fmt.Sprintf(":%d", liveRoll.StatusPort)
+			log.Printf("Starting status endpoint on %s", addr)
+			if err := http.ListenAndServe(addr, http.HandlerFunc(liveRoll.statusHandler)); err != nil {
+				log.Fatalf("Status server terminated: %v", err)
+			}
+		}()
+	}
+
 	// On first run, always execute the update process
-	liveRoll.triggerUpdate(true)
+	liveRoll.triggerUpdate(triggerStartup, true)
 
 	// Ticker for periodic updates
 	log.Printf("Starting update loop with Interval %v", liveRoll.Interval)
@@ -132,7 +521,7 @@ func (liveRoll *LiveRoll) Run() {
 			switch sig {
 			case syscall.SIGHUP:
 				log.Println("Received SIGHUP. Forcing restart process.")
-				liveRoll.triggerUpdate(true)
+				liveRoll.triggerUpdate(triggerSignal, true)
 			case syscall.SIGTERM, syscall.SIGINT:
 				log.Println("Received SIGTERM/SIGINT. Terminating child processes and shutting down.")
 				liveRoll.shutdown()
@@ -140,28 +529,69 @@ func (liveRoll *LiveRoll) Run() {
 			}
 		case <-ticker.C:
 			log.Println("Update Interval elapsed. Checking for updates.")
-			liveRoll.triggerUpdate(false)
+			liveRoll.triggerUpdate(triggerInterval, false)
 		}
 	}
 }
 
+// updateRequest is a request for an update process.
+type updateRequest struct {
+	// trigger is what requested the update process, e.g. triggerInterval.
+	trigger string
+	forced  bool
+	// rollbackID relaunches the given ID without running the pull and id commands.
+	rollbackID string
+}
+
 // updateLoop listens for update requests and triggers the update process.
 func (liveRoll *LiveRoll) updateLoop() {
-	for forced := range liveRoll.updateChan {
-		log.Printf("Processing update request(forced=%v)\n", forced)
-		if err := liveRoll.updateProcess(forced); err != nil {
-			log.Printf("Update process failed: %v(forced=%v)", err, forced)
+	for req := range liveRoll.updateChan {
+		log.Printf("Processing update request(trigger=%s, forced=%v, rollback=%q)\n", req.trigger, req.forced, req.rollbackID)
+		result := liveRoll.beginRollout(req)
+		err := liveRoll.updateProcess(req)
+		if err != nil {
+			log.Printf("Update process failed: %v(forced=%v)", err, req.forced)
 		}
+		liveRoll.finishRollout(result, err)
+		liveRoll.quarantineFailedRollout(result)
 	}
 }
 
 // triggerUpdate sends a signal to the update channel to trigger an update process.
-func (liveRoll *LiveRoll) triggerUpdate(forced bool) {
+func (liveRoll *LiveRoll) triggerUpdate(trigger string, forced bool) {
+	liveRoll.requestUpdate(updateRequest{trigger: trigger, forced: forced})
+}
+
+// requestUpdate queues an update request and reports whether it was accepted.
+// If an update request is already queued, the two are merged, so that the caller doesn't
+// block while an update process (e.g. waiting for promotion) is running and no request is lost.
+func (liveRoll *LiveRoll) requestUpdate(req updateRequest) bool {
 	if liveRoll.inShutdownProcess {
 		log.Println("Ignoring update request during shutdown process")
-		return
+		return false
+	}
+	liveRoll.updateRequestMutex.Lock()
+	defer liveRoll.updateRequestMutex.Unlock()
+	select {
+	case pending := <-liveRoll.updateChan:
+		req = mergeUpdateRequests(pending, req)
+		log.Printf("Merged update request into the queued one(trigger=%s, forced=%v, rollback=%q)", req.trigger, req.forced, req.rollbackID)
+	default:
 	}
-	liveRoll.updateChan <- forced
+	// Only senders holding updateRequestMutex fill the channel, so it has room now.
+	liveRoll.updateChan <- req
+	return true
+}
+
+// mergeUpdateRequests combines a queued update request with a newer one: a forced request
+// or a rollback wins, and the newest trigger is kept unless a queued rollback overrides it.
+func mergeUpdateRequests(pending, req updateRequest) updateRequest {
+	req.forced = req.forced || pending.forced
+	if req.rollbackID == "" && pending.rollbackID != "" {
+		req.rollbackID = pending.rollbackID
+		req.trigger = pending.trigger
+	}
+	return req
 }
 
 // shutdown sends SIGTERM to all child processes and exits the program.
@@ -174,7 +604,15 @@ func (liveRoll *LiveRoll) shutdown() {
 	liveRoll.inShutdownProcess = true
 
 	sendSignalForAllChildren := func(signal syscall.Signal) {
-		for port, child := range liveRoll.children {
+		all := make([]*ChildProcess, 0, len(liveRoll.children)+len(liveRoll.draining))
+		for _, child := range liveRoll.children {
+			all = append(all, child)
+		}
+		for _, child := range liveRoll.draining {
+			all = append(all, child)
+		}
+		for _, child := range all {
+			port := child.port
 			log.Printf("Sending signal %v to child process on port %d, pid=%s", signal, port, child.id)
 			if child.cmd != nil && child.cmd.Process != nil {
 				err := child.cmd.Process.Signal(signal)
@@ -208,6 +646,11 @@ func (liveRoll *LiveRoll) shutdown() {
 		return false
 	}
 
+	if liveRoll.candidate != nil && liveRoll.candidate.cmd != nil && liveRoll.candidate.cmd.Process != nil {
+		log.Printf("Sending SIGTERM to the candidate on port %d", liveRoll.candidate.port)
+		_ = liveRoll.candidate.cmd.Process.Signal(syscall.SIGTERM)
+	}
+
 	log.Printf("Sending SIGTERM to all child processes")
 	sendSignalForAllChildren(syscall.SIGTERM)
 
@@ -225,28 +668,75 @@ func (liveRoll *LiveRoll) shutdown() {
 
 // updateProcess executes the pull and id commands and launches a new child process if needed.
 // If forced is true, the update process is executed even if the new ID matches the current ID.
-func (liveRoll *LiveRoll) updateProcess(forced bool) error {
+// While pinned, only forced updates run, and they relaunch the pinned ID.
+func (liveRoll *LiveRoll) updateProcess(req updateRequest) error {
 	log.Println("Starting update process")
-	// 1. Execute the pull command
-	if err := runCommand(liveRoll.PullCmdStr); err != nil {
-		return fmt.Errorf("pull command failed: %v", err)
-	}
-	log.Println("Pull command executed successfully")
+	forced := req.forced
+
+	var newID string
+	pinned := liveRoll.pinnedID()
+	switch {
+	case req.rollbackID != "":
+		newID = req.rollbackID
+		log.Printf("Rolling back to ID: %s", newID)
+	case pinned != "":
+		if !forced {
+			if liveRoll.CheckUpdatesWhilePinned {
+				if err := liveRoll.checkAvailableID(pinned); err != nil {
+					return err
+				}
+			}
+			log.Printf("Pinned to %s. Skipping update.", pinned)
+			liveRoll.updateRollout(func(r *RolloutResult) { r.Outcome = outcomeSkipped })
+			return nil
+		}
+		newID = pinned
+		log.Printf("Pinned to %s. Relaunching the pinned ID.", pinned)
+	default:
+		// 1. Execute the pull command
+		start := time.Now()
+		err := runCommand(liveRoll.PullCmdStr)
+		liveRoll.recordPhase("pull", start)
+		if err != nil {
+			return fmt.Errorf("pull command failed: %v", err)
+		}
+		log.Println("Pull command executed successfully")
 
-	// 2. Execute the id command to obtain the new ID
-	newID, err := runCommandOutput(liveRoll.IdCmdStr)
-	if err != nil {
-		return fmt.Errorf("id command failed: %v", err)
+		// 2. Execute the id command to obtain the new ID
+		start = time.Now()
+		out, err := runCommandOutput(liveRoll.IdCmdStr)
+		liveRoll.recordPhase("id", start)
+		if err != nil {
+			return fmt.Errorf("id command failed: %v", err)
+		}
+		newID = strings.TrimSpace(out)
+		log.Printf("New ID: %s", newID)
 	}
-	newID = strings.TrimSpace(newID)
-	log.Printf("New ID: %s", newID)
+	liveRoll.updateRollout(func(r *RolloutResult) { r.NewID = newID })
 
 	liveRoll.currentIDMutex.Lock()
 	current := liveRoll.currentID
+	if newID == current {
+		liveRoll.deferredID = ""
+	}
 	liveRoll.currentIDMutex.Unlock()
 
 	if !forced && newID == current {
 		log.Println("ID unchanged. No update required.")
+		liveRoll.updateRollout(func(r *RolloutResult) { r.Outcome = outcomeUnchanged })
+		return nil
+	}
+
+	// Interval updates skip an ID that recently failed to roll out.
+	if q, ok := liveRoll.quarantinedID(newID, time.Now()); ok && !forced {
+		log.Printf("ID %s is quarantined until %v after %d failure(s): %s. Skipping update.", newID, q.Until, q.Failures, q.Reason)
+		liveRoll.updateRollout(func(r *RolloutResult) { r.Outcome = outcomeQuarantined })
+		return nil
+	}
+
+	// Interval updates only promote a new ID within the rollout windows.
+	if !forced && !liveRoll.rolloutAllowed(time.Now()) {
+		liveRoll.deferRollout(newID)
 		return nil
 	}
 
@@ -258,32 +748,110 @@ func (liveRoll *LiveRoll) updateProcess(forced bool) error {
 	log.Printf("Assigning port %d for new child process", portToUse)
 
 	// 4. Launch the child process (perform template substitution on the exec command)
+	start := time.Now()
 	child, err := liveRoll.startChildProcess(portToUse, newID)
+	liveRoll.recordPhase("launch", start)
 	if err != nil {
 		return fmt.Errorf("failed to launch child process: %v", err)
 	}
 
 	// 5. Perform healthcheck (wait until a HTTP 200 response is received)
-	if err := liveRoll.waitForHealth(child); err != nil {
+	start = time.Now()
+	err = liveRoll.waitForHealth(child)
+	liveRoll.recordPhase("health", start)
+	if err != nil {
 		log.Printf("Healthcheck failed for child process on port %d: %v", portToUse, err)
 		killChild(child)
 		return fmt.Errorf("healthcheck failed: %v", err)
 	}
 	log.Printf("Child process on port %d passed healthcheck", portToUse)
 
-	// 6. Register the child process and add it to the reverse proxy backend list
+	// 6. Run the smoke test against the child process
+	start = time.Now()
+	err = liveRoll.runSmokeTest(child)
+	if liveRoll.SmokeTestCmdStr != "" {
+		liveRoll.recordPhase("smoke_test", start)
+	}
+	if err != nil {
+		log.Printf("Smoke test failed for child process on port %d: %v", portToUse, err)
+		killChild(child)
+		return fmt.Errorf("smoke test failed: %v", err)
+	}
+
+	// 7. Warm up the child process before it receives traffic
+	start = time.Now()
+	err = liveRoll.warmup(child)
+	if liveRoll.WarmupFile != "" {
+		liveRoll.recordPhase("warmup", start)
+	}
+	if err != nil {
+		log.Printf("Warmup failed for child process on port %d: %v", portToUse, err)
+		killChild(child)
+		return fmt.Errorf("warmup failed: %v", err)
+	}
+
+	// 8. Mirror traffic to the child process, then hold it until it is promoted (only when an old child is serving)
+	if liveRoll.ShadowPercent > 0 && liveRoll.ShadowDuration > 0 && len(liveRoll.otherBackendPorts(portToUse)) > 0 {
+		start = time.Now()
+		err := liveRoll.shadowPhase(child)
+		liveRoll.recordPhase("shadow", start)
+		if err != nil {
+			killChild(child)
+			return fmt.Errorf("shadow phase failed: %v", err)
+		}
+	}
+	if liveRoll.ManualPromotion && len(liveRoll.otherBackendPorts(portToUse)) > 0 {
+		start = time.Now()
+		err := liveRoll.waitForPromotion(child)
+		liveRoll.recordPhase("promotion", start)
+		if err != nil {
+			log.Printf("Child process on port %d was not promoted: %v", portToUse, err)
+			killChild(child)
+			return fmt.Errorf("manual promotion failed: %w", err)
+		}
+	}
+
+	// 9. Register the child process and add it to the reverse proxy backend list
 	liveRoll.childrenMutex.Lock()
 	liveRoll.children[portToUse] = child
 	liveRoll.childrenMutex.Unlock()
-	liveRoll.addBackend(child)
+	start = time.Now()
+	if len(liveRoll.CanarySteps) > 0 {
+		err = liveRoll.canaryRollout(child)
+		liveRoll.recordPhase("canary", start)
+		if err != nil {
+			// The child served part of the traffic, so its in-flight requests are completed.
+			liveRoll.drainAndStop([]*ChildProcess{child})
+			return fmt.Errorf("canary rollout failed: %w", err)
+		}
+	} else {
+		err = liveRoll.bakeRollout(child)
+		liveRoll.recordPhase("bake", start)
+		if err != nil {
+			liveRoll.drainAndStop([]*ChildProcess{child})
+			return fmt.Errorf("bake failed: %w", err)
+		}
+	}
+	liveRoll.startupDone.Store(true)
 
-	// 7. Update the currentID
+	// 10. Update the currentID
 	liveRoll.currentIDMutex.Lock()
+	oldID := liveRoll.currentID
 	liveRoll.currentID = newID
+	liveRoll.deferredID = ""
 	liveRoll.currentIDMutex.Unlock()
 
-	// 8. Terminate old child processes (those with an ID different from newID)
+	if req.rollbackID != "" {
+		liveRoll.recordRollback(newID, oldID)
+		// Keep the rolled back ID until the pin is cleared.
+		liveRoll.setPinnedID(newID)
+	} else {
+		liveRoll.recordDeployment(newID)
+	}
+
+	// 11. Terminate old child processes (those with an ID different from newID)
 	liveRoll.removeStaleChildren(newID, portToUse)
+	liveRoll.resetBackendWeights()
 
 	return nil
 }
@@ -311,49 +879,68 @@ func runCommandOutput(cmdStr string) (string, error) {
 // the one that does not match the currentID or, if both match, arbitrarily terminates one.
 func (liveRoll *LiveRoll) selectChildPort() int {
 	liveRoll.childrenMutex.Lock()
-	defer liveRoll.childrenMutex.Unlock()
-
-	_, exists1 := liveRoll.children[liveRoll.ChildPort1]
-	_, exists2 := liveRoll.children[liveRoll.ChildPort2]
-	if !exists1 {
+	inUse := func(port int) bool {
+		_, exists := liveRoll.children[port]
+		_, draining := liveRoll.draining[port]
+		return exists || draining
+	}
+	if !inUse(liveRoll.ChildPort1) {
+		liveRoll.childrenMutex.Unlock()
 		return liveRoll.ChildPort1
 	}
-	if !exists2 {
+	if !inUse(liveRoll.ChildPort2) {
+		liveRoll.childrenMutex.Unlock()
 		return liveRoll.ChildPort2
 	}
 
-	// Both ports are in use. Terminate the one that does not match the current ID.
+	// Both ports are in use. Wait for an old child process that is already draining.
+	for _, port := range []int{liveRoll.ChildPort1, liveRoll.ChildPort2} {
+		if _, draining := liveRoll.draining[port]; draining {
+			liveRoll.childrenMutex.Unlock()
+			log.Printf("Both ports in use. Waiting for the old child process on port %d to drain", port)
+			for inUse := true; inUse; {
+				time.Sleep(drainCheckInterval)
+				liveRoll.childrenMutex.Lock()
+				_, inUse = liveRoll.draining[port]
+				liveRoll.childrenMutex.Unlock()
+			}
+			return port
+		}
+	}
+
+	// Otherwise drain and terminate the one that does not match the current ID.
+	// If both processes are current, arbitrarily take the one on ChildPort1.
 	liveRoll.currentIDMutex.Lock()
 	current := liveRoll.currentID
 	liveRoll.currentIDMutex.Unlock()
-	if liveRoll.children[liveRoll.ChildPort1].id != current {
-		log.Printf("Both ports in use. Terminating process on port %d", liveRoll.ChildPort1)
-		killChild(liveRoll.children[liveRoll.ChildPort1])
-		delete(liveRoll.children, liveRoll.ChildPort1)
-		liveRoll.removeBackendByPort(liveRoll.ChildPort1)
-		return liveRoll.ChildPort1
-	}
-	if liveRoll.children[liveRoll.ChildPort2].id != current {
-		log.Printf("Both ports in use. Terminating process on port %d", liveRoll.ChildPort2)
-		killChild(liveRoll.children[liveRoll.ChildPort2])
-		delete(liveRoll.children, liveRoll.ChildPort2)
-		liveRoll.removeBackendByPort(liveRoll.ChildPort2)
-		return liveRoll.ChildPort2
+	port := liveRoll.ChildPort1
+	if liveRoll.children[port].id == current && liveRoll.children[liveRoll.ChildPort2].id != current {
+		port = liveRoll.ChildPort2
 	}
+	child := liveRoll.children[port]
+	delete(liveRoll.children, port)
+	liveRoll.draining[port] = child
+	liveRoll.childrenMutex.Unlock()
+
+	log.Printf("Both ports in use. Draining and terminating process on port %d", port)
+	liveRoll.drainAndStop([]*ChildProcess{child})
+	return port
+}
 
-	// If both processes are current, arbitrarily terminate the one on ChildPort1.
-	log.Printf("Both child processes are current. Terminating process on port %d", liveRoll.ChildPort1)
-	killChild(liveRoll.children[liveRoll.ChildPort1])
-	delete(liveRoll.children, liveRoll.ChildPort1)
-	liveRoll.removeBackendByPort(liveRoll.ChildPort1)
-	return liveRoll.ChildPort1
+// expandTemplate replaces the template variables for the child process on port with the given ID.
+func (liveRoll *LiveRoll) expandTemplate(cmdStr string, port int, id string) string {
+	return strings.NewReplacer(
+		"<<PORT>>", fmt.Sprintf("%d", port),
+		"<<HEALTHCHECK>>", liveRoll.HealthcheckPath,
+		"<<ID>>", id,
+		"<<URL>>", backendURLForPort(port),
+	).Replace(cmdStr)
 }
 
 // startChildProcess performs template substitution on the exec command and launches the child process.
 func (liveRoll *LiveRoll) startChildProcess(port int, newID string) (*ChildProcess, error) {
-	// Replace template variables <<PORT>> and <<HEALTHCHECK>> in ExecCmdStr.
-	cmdStr := strings.ReplaceAll(liveRoll.ExecCmdStr, "<<PORT>>", fmt.Sprintf("%d", port))
-	cmdStr = strings.ReplaceAll(cmdStr, "<<HEALTHCHECK>>", liveRoll.HealthcheckPath)
+	// Replace template variables <<PORT>>, <<HEALTHCHECK>>, <<ID>> and <<URL>> in ExecCmdStr.
+	cmdStr := liveRoll.expandTemplate(liveRoll.ExecCmdStr, port, newID)
 	log.Printf("Child process launch command: %s", cmdStr)
 	cmd := exec.Command("sh", "-c", cmdStr)
 	cmd.Stdout = os.Stdout
@@ -363,12 +950,14 @@ func (liveRoll *LiveRoll) startChildProcess(port int, newID string) (*ChildProce
 	if err := cmd.Start(); err != nil {
 		return nil, err
 	}
-	healthURL := fmt.Sprintf("http://localhost:%d%s", port, liveRoll.HealthcheckPath)
+	healthURL := liveRoll.healthURLForPort(port)
 	child := &ChildProcess{
 		port:      port,
 		id:        newID,
 		cmd:       cmd,
 		healthURL: healthURL,
+		exited:    make(chan struct{}),
+		startedAt: time.Now(),
 	}
 
 	// Start a goroutine to monitor the child process termination.
@@ -395,17 +984,23 @@ func (liveRoll *LiveRoll) startChildProcess(port int, newID string) (*ChildProce
 		} else {
 			log.Printf("Child process on port %d terminated normally (exit code 0)", port)
 		}
+		close(ch.exited)
 
 		// On termination, remove the child from global management and the reverse proxy.
 		liveRoll.childrenMutex.Lock()
-		delete(liveRoll.children, port)
+		if liveRoll.children[port] == ch {
+			delete(liveRoll.children, port)
+		}
+		remaining := len(liveRoll.children)
+		// A waiting candidate is promoted when the old children are gone.
+		waiting := liveRoll.candidate != nil
 		liveRoll.childrenMutex.Unlock()
 		liveRoll.removeBackend(ch)
 
 		// If there's no child process running, trigger an update process.
-		if len(liveRoll.children) == 0 {
+		if remaining == 0 && !waiting {
 			log.Println("No child processes running. Triggering update process.")
-			liveRoll.triggerUpdate(true)
+			liveRoll.triggerUpdate(triggerCrash, true)
 		}
 	}(child)
 
@@ -413,23 +1008,114 @@ func (liveRoll *LiveRoll) startChildProcess(port int, newID string) (*ChildProce
 }
 
 // waitForHealth waits until the child process's healthcheck endpoint returns HTTP 200.
+// Each attempt is bounded by HealthAttemptTimeout, and the reason of the last failure
+// is included in the returned error.
 func (liveRoll *LiveRoll) waitForHealth(child *ChildProcess) error {
-	interval := 1 * time.Second
+	interval := liveRoll.HealthInterval
+	if interval <= 0 {
+		interval = 1 * time.Second
+	}
+	client, err := liveRoll.newHealthClient(child)
+	if err != nil {
+		return err
+	}
 	deadline := time.Now().Add(liveRoll.HealthTimeout)
+	attempts := 0
+	var lastErr error
 	for time.Now().Before(deadline) {
-		resp, err := http.Get(child.healthURL)
-		if err == nil {
-			// Discard the response body.
-			_, _ = io.ReadAll(resp.Body)
-			resp.Body.Close()
-			if resp.StatusCode == http.StatusOK {
-				return nil
-			}
+		attempts++
+		lastErr = liveRoll.checkHealth(client, child.healthURL, time.Until(deadline))
+		if lastErr == nil {
+			return nil
+		}
+		log.Printf("Healthcheck failed for port %d: %v. Retrying in %v", child.port, lastErr, interval)
+		if remaining := time.Until(deadline); remaining < interval {
+			time.Sleep(remaining)
+		} else {
+			time.Sleep(interval)
+		}
+		interval = liveRoll.nextHealthInterval(interval)
+	}
+	if lastErr == nil {
+		return fmt.Errorf("healthcheck timed out")
+	}
+	return fmt.Errorf("healthcheck timed out after %d attempts: %w", attempts, lastErr)
+}
+
+// checkHealth performs a single healthcheck request.
+func (liveRoll *LiveRoll) checkHealth(client *http.Client, healthURL string, remaining time.Duration) error {
+	timeout := liveRoll.HealthAttemptTimeout
+	if timeout <= 0 || timeout > remaining {
+		timeout = remaining
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, healthURL, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		switch {
+		case errors.Is(err, syscall.ECONNREFUSED):
+			return fmt.Errorf("connection refused")
+		case errors.Is(err, context.DeadlineExceeded) || os.IsTimeout(err):
+			return fmt.Errorf("timeout after %v", timeout)
 		}
-		log.Printf("Healthcheck failed for port %d. Retrying in %v", child.port, interval)
-		time.Sleep(interval)
+		return err
+	}
+	defer resp.Body.Close()
+	// Discard the response body.
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
 	}
-	return fmt.Errorf("healthcheck timed out")
+	return nil
+}
+
+// nextHealthInterval applies HealthBackoff to the interval, capped by HealthMaxInterval.
+func (liveRoll *LiveRoll) nextHealthInterval(interval time.Duration) time.Duration {
+	if liveRoll.HealthBackoff <= 1 {
+		return interval
+	}
+	next := time.Duration(float64(interval) * liveRoll.HealthBackoff)
+	if liveRoll.HealthMaxInterval > 0 && next > liveRoll.HealthMaxInterval {
+		next = liveRoll.HealthMaxInterval
+	}
+	return next
+}
+
+// runSmokeTest runs the smoke test command against the child process.
+// Template variables <<URL>>, <<PORT>>, <<ID>> and <<HEALTHCHECK>> are expanded before execution.
+func (liveRoll *LiveRoll) runSmokeTest(child *ChildProcess) error {
+	if liveRoll.SmokeTestCmdStr == "" {
+		return nil
+	}
+	cmdStr := liveRoll.expandTemplate(liveRoll.SmokeTestCmdStr, child.port, child.id)
+	log.Printf("Executing smoke test: %s", cmdStr)
+
+	ctx := context.Background()
+	if liveRoll.SmokeTestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, liveRoll.SmokeTestTimeout)
+		defer cancel()
+	}
+	cmd := exec.CommandContext(ctx, "sh", "-c", cmdStr)
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// Kill the whole process group on timeout so that commands spawned by the shell don't linger.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("timed out after %v", liveRoll.SmokeTestTimeout)
+		}
+		return err
+	}
+	return nil
 }
 
 // killChild sends a termination signal to the child process.
@@ -440,58 +1126,68 @@ func killChild(child *ChildProcess) {
 	}
 }
 
-// removeStaleChildren terminates child processes that do not have the newID.
+// removeStaleChildren drains and terminates child processes that do not have the newID.
 func (liveRoll *LiveRoll) removeStaleChildren(newID string, newPort int) {
+	var stale []*ChildProcess
 	liveRoll.childrenMutex.Lock()
-	defer liveRoll.childrenMutex.Unlock()
 	for port, child := range liveRoll.children {
 		if port != newPort && child.id != newID {
-			log.Printf("Sending SIGTERM to the old child process on port %d, pid=%v", port, child.cmd.Process.Pid)
-
-			// send SIGTERM to the child process
-			err := child.cmd.Process.Signal(syscall.SIGTERM)
-			if err != nil {
-				log.Printf("Failed to send SIGTERM to child process on port %d, pid %v: %v",
-					port, child.cmd.Process.Pid, err)
-			}
-
-			if !waitProcessExit(child.cmd, 10*time.Millisecond, 1000) {
-				killChild(child)
-			}
-
+			stale = append(stale, child)
+			// Unregister right away so that a crash of the new child triggers a relaunch.
 			delete(liveRoll.children, port)
-			liveRoll.removeBackend(child)
+			liveRoll.draining[port] = child
 		}
 	}
+	liveRoll.childrenMutex.Unlock()
+
+	// Drain in the background so that the update loop keeps serving update requests.
+	go liveRoll.drainAndStop(stale)
 }
 
-func waitProcessExit(cmd *exec.Cmd, sleepTime time.Duration, retryCount int) bool {
-	for i := 0; i < retryCount; i++ {
-		if cmd.ProcessState != nil && cmd.ProcessState.Exited() {
-			return true
-		}
-		time.Sleep(sleepTime)
+// waitChildExit waits until the child process exits or timeout expires, and reports whether it exited.
+func waitChildExit(child *ChildProcess, timeout time.Duration) bool {
+	select {
+	case <-child.exited:
+		return true
+	case <-time.After(timeout):
+		return false
 	}
-	return false
 }
 
 // addBackend adds the child process's address to the reverse proxy.
 func (liveRoll *LiveRoll) addBackend(child *ChildProcess) {
+	liveRoll.addBackendWithWeight(child, 1)
+}
+
+// addBackendWithWeight adds the child process's address to the reverse proxy with the given weight.
+func (liveRoll *LiveRoll) addBackendWithWeight(child *ChildProcess, weight int) {
 	liveRoll.backendURLsMutex.Lock()
 	defer liveRoll.backendURLsMutex.Unlock()
-	urlStr := fmt.Sprintf("http://localhost:%d", child.port)
+	urlStr := backendURLForPort(child.port)
 	u, err := url.Parse(urlStr)
 	if err != nil {
 		log.Printf("Failed to parse backend URL %s: %v", urlStr, err)
 		return
 	}
+	liveRoll.backendURLs[child.port] = u
+	liveRoll.backendWeights[child.port] = weight
+	if until, ejected := liveRoll.ejected[urlStr]; ejected {
+		// The weight is applied when the backend is restored.
+		log.Printf("Added backend for port %d (weight=%d), ejected until %s", child.port, weight, until.Format(time.RFC3339))
+		return
+	}
 	// Add to the oxy round-robin load balancer.
-	err = liveRoll.lb.UpsertServer(u)
+	err = liveRoll.lb.UpsertServer(u, roundrobin.Weight(weight))
 	if err != nil {
 		log.Printf("[ERROR} Failed to add backend to load balancer: %v", err)
 	}
-	liveRoll.backendURLs[child.port] = u
-	log.Printf("Added backend for port %d", child.port)
+	log.Printf("Added backend for port %d (weight=%d)", child.port, weight)
+	liveRoll.notifyBackendsChanged()
+}
+
+// backendURLForPort returns the base URL of the child process listening on port.
+func backendURLForPort(port int) string {
+	return fmt.Sprintf("http://localhost:%d", port)
 }
 
 // removeBackend removes the child process's backend from the reverse proxy.
@@ -503,11 +1199,13 @@ func (liveRoll *LiveRoll) removeBackendByPort(port int) {
 	liveRoll.backendURLsMutex.Lock()
 	defer liveRoll.backendURLsMutex.Unlock()
 	if u, ok := liveRoll.backendURLs[port]; ok {
-		err := liveRoll.lb.RemoveServer(u)
-		if err != nil {
+		if _, ejected := liveRoll.ejected[u.String()]; ejected {
+			// Already out of the load balancer. The ejection still applies if the backend is added again.
+		} else if err := liveRoll.lb.RemoveServer(u); err != nil {
 			log.Print("[ERROR] Failed to remove backend from load balancer: ", err)
 		}
 		delete(liveRoll.backendURLs, port)
+		delete(liveRoll.backendWeights, port)
 		log.Printf("Removed backend for port %d", port)
 	}
 }
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -39,6 +40,8 @@ func createTestLiveRoll() *LiveRoll {
 	lr.ChildPort1 = 9101
 	lr.ChildPort2 = 9102
 	lr.HealthTimeout = 2 * time.Second
+	lr.HealthAttemptTimeout = 500 * time.Millisecond
+	lr.HealthInterval = 100 * time.Millisecond
 	return &lr
 }
 
@@ -72,8 +75,8 @@ func TestSelectChildPort_BothChildren_OneOld(t *testing.T) {
 	lr.currentIDMutex.Unlock()
 
 	lr.childrenMutex.Lock()
-	lr.children[lr.ChildPort1] = &ChildProcess{id: "old"}
-	lr.children[lr.ChildPort2] = &ChildProcess{id: "current"}
+	lr.children[lr.ChildPort1] = &ChildProcess{port: lr.ChildPort1, id: "old"}
+	lr.children[lr.ChildPort2] = &ChildProcess{port: lr.ChildPort2, id: "current"}
 	lr.childrenMutex.Unlock()
 
 	port := lr.selectChildPort()
@@ -97,8 +100,8 @@ func TestSelectChildPort_BothChildren_Current(t *testing.T) {
 	lr.currentIDMutex.Unlock()
 
 	lr.childrenMutex.Lock()
-	lr.children[lr.ChildPort1] = &ChildProcess{id: "current"}
-	lr.children[lr.ChildPort2] = &ChildProcess{id: "current"}
+	lr.children[lr.ChildPort1] = &ChildProcess{port: lr.ChildPort1, id: "current"}
+	lr.children[lr.ChildPort2] = &ChildProcess{port: lr.ChildPort2, id: "current"}
 	lr.childrenMutex.Unlock()
 
 	port := lr.selectChildPort()
@@ -113,6 +116,39 @@ func TestSelectChildPort_BothChildren_Current(t *testing.T) {
 	lr.childrenMutex.Unlock()
 }
 
+// TestSelectChildPort_Draining tests that a port is not reused until its old child process has drained.
+func TestSelectChildPort_Draining(t *testing.T) {
+	lr := createTestLiveRoll()
+
+	lr.currentIDMutex.Lock()
+	lr.currentID = "current"
+	lr.currentIDMutex.Unlock()
+
+	lr.childrenMutex.Lock()
+	lr.draining[lr.ChildPort1] = &ChildProcess{port: lr.ChildPort1, id: "old"}
+	lr.children[lr.ChildPort2] = &ChildProcess{port: lr.ChildPort2, id: "current"}
+	lr.childrenMutex.Unlock()
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		lr.childrenMutex.Lock()
+		delete(lr.draining, lr.ChildPort1)
+		lr.childrenMutex.Unlock()
+	}()
+
+	start := time.Now()
+	port := lr.selectChildPort()
+	if port != lr.ChildPort1 {
+		t.Errorf("Expected port %d to be selected after the drain, got %d", lr.ChildPort1, port)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Expected to wait for the drain, returned after %v", elapsed)
+	}
+	if _, exists := lr.children[lr.ChildPort2]; !exists {
+		t.Error("Expected the current child to be kept")
+	}
+}
+
 // TestWaitForHealth_Success tests that waitForHealth succeeds when a 200 OK response is received.
 func TestWaitForHealth_Success(t *testing.T) {
 	lr := createTestLiveRoll()
@@ -152,3 +188,104 @@ func TestWaitForHealth_Failure(t *testing.T) {
 		t.Error("Expected health check to fail, but it succeeded")
 	}
 }
+
+// TestWaitForHealth_ReportsStatusCode tests that the last failure reason is included in the error.
+func TestWaitForHealth_ReportsStatusCode(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.HealthTimeout = 500 * time.Millisecond
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	child := &ChildProcess{
+		port:      12345,
+		healthURL: ts.URL,
+	}
+
+	err := lr.waitForHealth(child)
+	if err == nil || !strings.Contains(err.Error(), "unexpected status code 503") {
+		t.Errorf("Expected error to mention status code 503, got: %v", err)
+	}
+}
+
+// TestWaitForHealth_AttemptTimeout tests that a hung healthcheck request does not consume the whole HealthTimeout.
+func TestWaitForHealth_AttemptTimeout(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.HealthAttemptTimeout = 100 * time.Millisecond
+
+	var calls atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if calls.Add(1) == 1 {
+			// Hang on the first request until the client gives up.
+			<-r.Context().Done()
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	child := &ChildProcess{
+		port:      12345,
+		healthURL: ts.URL,
+	}
+
+	if err := lr.waitForHealth(child); err != nil {
+		t.Errorf("Expected health check to succeed after a timed out attempt, got error: %v", err)
+	}
+}
+
+// TestNextHealthInterval tests the backoff calculation of the healthcheck interval.
+func TestNextHealthInterval(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.HealthBackoff = 2
+	lr.HealthMaxInterval = 3 * time.Second
+
+	if got := lr.nextHealthInterval(1 * time.Second); got != 2*time.Second {
+		t.Errorf("Expected 2s, got %v", got)
+	}
+	if got := lr.nextHealthInterval(2 * time.Second); got != 3*time.Second {
+		t.Errorf("Expected interval to be capped at 3s, got %v", got)
+	}
+
+	lr.HealthBackoff = 1
+	if got := lr.nextHealthInterval(1 * time.Second); got != 1*time.Second {
+		t.Errorf("Expected interval to stay at 1s without backoff, got %v", got)
+	}
+}
+
+// TestExpandTemplate tests the substitution of template variables.
+func TestExpandTemplate(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.HealthcheckPath = "/healthz"
+
+	got := lr.expandTemplate("curl <<URL>><<HEALTHCHECK>> -H 'X-Id: <<ID>>' # <<PORT>>", 9101, "v1")
+	want := "curl http://localhost:9101/healthz -H 'X-Id: v1' # 9101"
+	if got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
+
+// TestRunSmokeTest tests that the smoke test result is reported and templated.
+func TestRunSmokeTest(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.SmokeTestTimeout = 5 * time.Second
+	child := &ChildProcess{port: 9101, id: "v1"}
+
+	lr.SmokeTestCmdStr = `test "<<ID>>" = v1 && test "<<URL>>" = http://localhost:9101`
+	if err := lr.runSmokeTest(child); err != nil {
+		t.Errorf("Expected smoke test to succeed, got: %v", err)
+	}
+
+	lr.SmokeTestCmdStr = "exit 1"
+	if err := lr.runSmokeTest(child); err == nil {
+		t.Error("Expected smoke test to fail, but it succeeded")
+	}
+
+	lr.SmokeTestCmdStr = "sleep 10"
+	lr.SmokeTestTimeout = 100 * time.Millisecond
+	if err := lr.runSmokeTest(child); err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected smoke test to time out, got: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/vulcand/oxy/v2/roundrobin"
+)
+
+// backendStats holds the passive health statistics of a backend for the current window.
+type backendStats struct {
+	windowStart   time.Time
+	requests      int
+	errors        int
+	networkErrors int
+}
+
+// outlierDetector tracks error rates observed in proxied traffic (key: child process port).
+type outlierDetector struct {
+	mutex sync.Mutex
+	stats map[int]*backendStats
+}
+
+func newOutlierDetector() *outlierDetector {
+	return &outlierDetector{stats: make(map[int]*backendStats)}
+}
+
+// recordBackendResult updates the passive health statistics of the backend and
+// ejects it when its error rate exceeds OutlierErrorRate.
+func (liveRoll *LiveRoll) recordBackendResult(port int, status int, networkError bool) {
+	if liveRoll.OutlierErrorRate <= 0 || port == 0 {
+		return
+	}
+
+	d := liveRoll.outliers
+	d.mutex.Lock()
+	now := time.Now()
+	st, ok := d.stats[port]
+	if !ok || now.Sub(st.windowStart) > liveRoll.OutlierInterval {
+		st = &backendStats{windowStart: now}
+		d.stats[port] = st
+	}
+	st.requests++
+	if networkError {
+		st.networkErrors++
+	}
+	if networkError || status >= 500 {
+		st.errors++
+	}
+	rate := float64(st.errors) / float64(st.requests)
+	exceeded := st.requests >= liveRoll.OutlierMinRequests && rate >= liveRoll.OutlierErrorRate
+	snapshot := *st
+	if exceeded {
+		delete(d.stats, port)
+	}
+	d.mutex.Unlock()
+
+	if exceeded {
+		log.Printf("Backend on port %d exceeded error rate: %d/%d requests failed (%d network errors)",
+			port, snapshot.errors, snapshot.requests, snapshot.networkErrors)
+		liveRoll.ejectBackend(port)
+	}
+}
+
+// ejectBackend temporarily removes the backend from the load balancer.
+// Ejection is skipped if it would exceed OutlierMaxEjectionPercent or leave no backend.
+func (liveRoll *LiveRoll) ejectBackend(port int) {
+	liveRoll.backendURLsMutex.Lock()
+	defer liveRoll.backendURLsMutex.Unlock()
+
+	u, ok := liveRoll.backendURLs[port]
+	if !ok {
+		return
+	}
+	key := u.String()
+	if _, already := liveRoll.ejected[key]; already {
+		return
+	}
+	total := len(liveRoll.backendURLs)
+	ejected := 1
+	for p := range liveRoll.backendURLs {
+		if _, ok := liveRoll.ejectedUntil(p); ok {
+			ejected++
+		}
+	}
+	if ejected >= total || ejected*100 > total*liveRoll.OutlierMaxEjectionPercent {
+		log.Printf("Not ejecting backend on port %d: %d of %d backends would be ejected", port, ejected, total)
+		return
+	}
+
+	if err := liveRoll.lb.RemoveServer(u); err != nil {
+		log.Printf("[ERROR] Failed to eject backend on port %d: %v", port, err)
+		return
+	}
+	until := time.Now().Add(liveRoll.OutlierEjectionDuration)
+	liveRoll.ejected[key] = until
+	log.Printf("Ejected backend on port %d until %s", port, until.Format(time.RFC3339))
+
+	time.AfterFunc(liveRoll.OutlierEjectionDuration, func() {
+		liveRoll.restoreBackend(port, key, until)
+	})
+}
+
+// ejectedUntil returns until when the backend on port is ejected, if it is.
+// The caller must hold backendURLsMutex.
+func (liveRoll *LiveRoll) ejectedUntil(port int) (time.Time, bool) {
+	until, ok := liveRoll.ejected[backendURLForPort(port)]
+	return until, ok
+}
+
+// restoreBackend ends the ejection of the backend with URL key that lasted until, and returns
+// the backend to the load balancer if it is registered on port.
+func (liveRoll *LiveRoll) restoreBackend(port int, key string, until time.Time) {
+	liveRoll.backendURLsMutex.Lock()
+	defer liveRoll.backendURLsMutex.Unlock()
+
+	if current, ok := liveRoll.ejected[key]; !ok || !current.Equal(until) {
+		// The backend was ejected again in the meantime.
+		return
+	}
+	delete(liveRoll.ejected, key)
+	u, ok := liveRoll.backendURLs[port]
+	if !ok || u.String() != key {
+		// The backend was removed during the ejection.
+		return
+	}
+	weight := liveRoll.backendWeights[port]
+	if err := liveRoll.lb.UpsertServer(u, roundrobin.Weight(weight)); err != nil {
+		log.Printf("[ERROR] Failed to restore backend on port %d: %v", port, err)
+		return
+	}
+	if weight == 0 {
+		// oxy adds new servers with weight 0 as weight 1, so the weight of a backend
+		// being drained is applied to the server once it exists.
+		if err := liveRoll.lb.UpsertServer(u, roundrobin.Weight(0)); err != nil {
+			log.Printf("[ERROR] Failed to set weight of backend on port %d: %v", port, err)
+		}
+	}
+	log.Printf("Restored ejected backend on port %d", port)
+	liveRoll.notifyBackendsChanged()
+}
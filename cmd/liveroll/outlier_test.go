@@ -0,0 +1,133 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/vulcand/oxy/v2/roundrobin"
+)
+
+// createTestLiveRollWithBackends creates a LiveRoll with a load balancer and backends registered on the given ports.
+func createTestLiveRollWithBackends(t *testing.T, ports ...int) *LiveRoll {
+	t.Helper()
+	lr := createTestLiveRoll()
+	var err error
+	lr.lb, err = roundrobin.New(http.NotFoundHandler())
+	if err != nil {
+		t.Fatalf("Failed to create roundrobin: %v", err)
+	}
+	for _, port := range ports {
+		lr.addBackend(&ChildProcess{port: port})
+	}
+	return lr
+}
+
+// TestOutlierEjection tests that a failing backend is ejected and restored after the ejection duration.
+func TestOutlierEjection(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t, 9101, 9102)
+	lr.OutlierErrorRate = 0.5
+	lr.OutlierMinRequests = 4
+	lr.OutlierInterval = 10 * time.Second
+	lr.OutlierEjectionDuration = 200 * time.Millisecond
+	lr.OutlierMaxEjectionPercent = 50
+
+	for i := 0; i < 4; i++ {
+		lr.recordBackendResult(9101, http.StatusBadGateway, true)
+		lr.recordBackendResult(9102, http.StatusOK, false)
+	}
+
+	if servers := lr.lb.Servers(); len(servers) != 1 || portFromURL(servers[0]) != 9102 {
+		t.Fatalf("Expected only port 9102 in the load balancer, got %v", servers)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if servers := lr.lb.Servers(); len(servers) != 2 {
+		t.Errorf("Expected ejected backend to be restored, got %v", servers)
+	}
+}
+
+// TestOutlierEjection_ReAdded tests that a backend removed and added again during its
+// ejection stays out of the load balancer until the ejection ends.
+func TestOutlierEjection_ReAdded(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t, 9101, 9102)
+	lr.OutlierErrorRate = 0.5
+	lr.OutlierMinRequests = 4
+	lr.OutlierInterval = 10 * time.Second
+	lr.OutlierEjectionDuration = 200 * time.Millisecond
+	lr.OutlierMaxEjectionPercent = 50
+
+	for i := 0; i < 4; i++ {
+		lr.recordBackendResult(9101, http.StatusBadGateway, true)
+		lr.recordBackendResult(9102, http.StatusOK, false)
+	}
+	lr.removeBackendByPort(9101)
+	lr.addBackend(&ChildProcess{port: 9101})
+	if servers := lr.lb.Servers(); len(servers) != 1 || portFromURL(servers[0]) != 9102 {
+		t.Fatalf("Expected port 9101 to stay ejected, got %v", servers)
+	}
+	if st := lr.status(); st.Slots[0].EjectedUntil == nil {
+		t.Errorf("Expected the status to report the ejection, got %+v", st.Slots[0])
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if servers := lr.lb.Servers(); len(servers) != 2 {
+		t.Errorf("Expected the re-added backend to be restored, got %v", servers)
+	}
+}
+
+// TestOutlierEjection_KeepsWeightZero tests that a backend with weight 0, e.g. an old child
+// being drained, doesn't receive new requests again when its ejection ends.
+func TestOutlierEjection_KeepsWeightZero(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t, 9101, 9102)
+	lr.OutlierErrorRate = 0.5
+	lr.OutlierMinRequests = 4
+	lr.OutlierInterval = 10 * time.Second
+	lr.OutlierEjectionDuration = 200 * time.Millisecond
+	lr.OutlierMaxEjectionPercent = 50
+	lr.setBackendWeight(9101, 0)
+
+	for i := 0; i < 4; i++ {
+		lr.recordBackendResult(9101, http.StatusBadGateway, true)
+		lr.recordBackendResult(9102, http.StatusOK, false)
+	}
+	if servers := lr.lb.Servers(); len(servers) != 1 {
+		t.Fatalf("Expected port 9101 to be ejected, got %v", servers)
+	}
+
+	time.Sleep(400 * time.Millisecond)
+	if servers := lr.lb.Servers(); len(servers) != 2 {
+		t.Fatalf("Expected ejected backend to be restored, got %v", servers)
+	}
+	u, _ := url.Parse(backendURLForPort(9101))
+	if weight, ok := lr.lb.ServerWeight(u); !ok || weight != 0 {
+		t.Errorf("Expected the restored backend to keep weight 0, got %d", weight)
+	}
+}
+
+// TestOutlierEjection_NeverEjectsAll tests that the last backend is never ejected.
+func TestOutlierEjection_NeverEjectsAll(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t, 9101, 9102)
+	lr.OutlierErrorRate = 0.5
+	lr.OutlierMinRequests = 2
+	lr.OutlierInterval = 10 * time.Second
+	lr.OutlierEjectionDuration = 10 * time.Second
+	lr.OutlierMaxEjectionPercent = 100
+
+	for i := 0; i < 2; i++ {
+		lr.recordBackendResult(9101, http.StatusInternalServerError, false)
+		lr.recordBackendResult(9102, http.StatusInternalServerError, false)
+	}
+
+	if servers := lr.lb.Servers(); len(servers) != 1 {
+		t.Errorf("Expected exactly one backend to remain, got %v", servers)
+	}
+
+	// Removing an ejected backend must not fail.
+	lr.removeBackendByPort(9101)
+	lr.removeBackendByPort(9102)
+	if servers := lr.lb.Servers(); len(servers) != 0 || len(lr.backendURLs) != 0 {
+		t.Errorf("Expected the backends to be removed, got servers=%v backends=%v", servers, lr.backendURLs)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// pinPath returns the path of the file persisting the pin in the state directory.
+func (liveRoll *LiveRoll) pinPath() string {
+	return filepath.Join(liveRoll.StateDir, "pin")
+}
+
+// loadPin restores the pin persisted in the state directory.
+func (liveRoll *LiveRoll) loadPin() error {
+	if liveRoll.StateDir == "" {
+		return nil
+	}
+	data, err := os.ReadFile(liveRoll.pinPath())
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if id := strings.TrimSpace(string(data)); id != "" {
+		log.Printf("Restored pin to %s", id)
+		liveRoll.currentIDMutex.Lock()
+		liveRoll.pinned = id
+		liveRoll.currentIDMutex.Unlock()
+	}
+	return nil
+}
+
+// pinnedID returns the ID that updates are pinned to, or "" if not pinned.
+func (liveRoll *LiveRoll) pinnedID() string {
+	liveRoll.currentIDMutex.Lock()
+	defer liveRoll.currentIDMutex.Unlock()
+	return liveRoll.pinned
+}
+
+// setPinnedID pins updates to id and persists the pin. An empty id clears the pin.
+func (liveRoll *LiveRoll) setPinnedID(id string) {
+	liveRoll.currentIDMutex.Lock()
+	if id == "" {
+		log.Printf("Unpinned %s", liveRoll.pinned)
+		liveRoll.availableID = ""
+	} else {
+		log.Printf("Pinned to %s", id)
+	}
+	liveRoll.pinned = id
+	liveRoll.currentIDMutex.Unlock()
+
+	if liveRoll.StateDir == "" {
+		if id != "" {
+			// Also reached by rollbacks, which pin the rolled back ID.
+			log.Printf("Warning: the pin to %s is kept in memory only and is lost on restart. Set --state-dir to persist it", id)
+		}
+		return
+	}
+	var err error
+	if id == "" {
+		err = os.Remove(liveRoll.pinPath())
+		if errors.Is(err, os.ErrNotExist) {
+			err = nil
+		}
+	} else {
+		err = writeFileAtomic(liveRoll.pinPath(), []byte(id+"\n"))
+	}
+	if err != nil {
+		log.Printf("Failed to persist pin: %v", err)
+	}
+}
+
+// pinCurrent pins updates to the current ID.
+func (liveRoll *LiveRoll) pinCurrent() (string, error) {
+	liveRoll.currentIDMutex.Lock()
+	current := liveRoll.currentID
+	liveRoll.currentIDMutex.Unlock()
+	if current == "" {
+		return "", fmt.Errorf("no ID has been deployed yet")
+	}
+	liveRoll.setPinnedID(current)
+	return current, nil
+}
+
+// checkAvailableID runs the pull and id commands while pinned and remembers
+// the newly available ID without promoting it.
+func (liveRoll *LiveRoll) checkAvailableID(pinned string) error {
+	if err := runCommand(liveRoll.PullCmdStr); err != nil {
+		return fmt.Errorf("pull command failed: %v", err)
+	}
+	out, err := runCommandOutput(liveRoll.IdCmdStr)
+	if err != nil {
+		return fmt.Errorf("id command failed: %v", err)
+	}
+	id := strings.TrimSpace(out)
+
+	liveRoll.currentIDMutex.Lock()
+	defer liveRoll.currentIDMutex.Unlock()
+	if id == pinned {
+		liveRoll.availableID = ""
+		return nil
+	}
+	if id != liveRoll.availableID {
+		log.Printf("New ID %s is available but liveroll is pinned to %s", id, pinned)
+	}
+	liveRoll.availableID = id
+	return nil
+}
+
+// availableIDWhilePinned returns the ID found while pinned, if any.
+func (liveRoll *LiveRoll) availableIDWhilePinned() string {
+	liveRoll.currentIDMutex.Lock()
+	defer liveRoll.currentIDMutex.Unlock()
+	return liveRoll.availableID
+}
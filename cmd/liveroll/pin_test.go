@@ -0,0 +1,60 @@
+package main
+
+import (
+	"testing"
+)
+
+// TestPinPersistence tests that the pin survives a restart and is removed when cleared.
+func TestPinPersistence(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.StateDir = t.TempDir()
+	if _, err := lr.pinCurrent(); err == nil {
+		t.Error("Expected error when pinning without a current ID")
+	}
+
+	lr.currentID = "v1"
+	if id, err := lr.pinCurrent(); err != nil || id != "v1" {
+		t.Fatalf("Expected pin to v1, got %q (%v)", id, err)
+	}
+
+	restored := createTestLiveRoll()
+	restored.StateDir = lr.StateDir
+	if err := restored.loadPin(); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if restored.pinnedID() != "v1" {
+		t.Errorf("Expected restored pin v1, got %q", restored.pinnedID())
+	}
+
+	restored.setPinnedID("")
+	again := createTestLiveRoll()
+	again.StateDir = lr.StateDir
+	if err := again.loadPin(); err != nil || again.pinnedID() != "" {
+		t.Errorf("Expected pin to be cleared, got %q (%v)", again.pinnedID(), err)
+	}
+}
+
+// TestUpdateProcess_PinnedReportsAvailableID tests that a newer ID is reported but not promoted while pinned.
+func TestUpdateProcess_PinnedReportsAvailableID(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.PullCmdStr = "true"
+	lr.IdCmdStr = "echo v2"
+	lr.CheckUpdatesWhilePinned = true
+	lr.currentID = "v1"
+	lr.setPinnedID("v1")
+
+	if err := lr.updateProcess(updateRequest{forced: false}); err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if got := lr.availableIDWhilePinned(); got != "v2" {
+		t.Errorf("Expected available ID v2, got %q", got)
+	}
+	if lr.currentID != "v1" {
+		t.Errorf("Expected current ID to stay v1, got %q", lr.currentID)
+	}
+
+	lr.setPinnedID("")
+	if got := lr.availableIDWhilePinned(); got != "" {
+		t.Errorf("Expected available ID to be cleared on unpin, got %q", got)
+	}
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// errPromotionAborted is returned when a candidate is aborted by an operator or by the promotion timeout.
+var errPromotionAborted = errors.New("promotion aborted")
+
+// errNoCandidate is returned when promote or abort is requested while no candidate is waiting.
+var errNoCandidate = errors.New("no candidate is waiting for promotion")
+
+// waitForPromotion holds the new child process out of the load balancer until an operator
+// promotes or aborts it, or PromotionTimeout expires. If every old child process exits in the
+// meantime, the candidate is promoted so that requests are served again without waiting for
+// an operator.
+func (liveRoll *LiveRoll) waitForPromotion(child *ChildProcess) error {
+	liveRoll.childrenMutex.Lock()
+	liveRoll.candidate = child
+	liveRoll.promotionChan = make(chan bool, 1)
+	decision := liveRoll.promotionChan
+	liveRoll.childrenMutex.Unlock()
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	oldExited := childrenExited(liveRoll.otherChildren(child), stopWatching)
+	liveRoll.startShadow(child)
+	defer func() {
+		liveRoll.childrenMutex.Lock()
+		liveRoll.candidate = nil
+		liveRoll.promotionChan = nil
+		liveRoll.childrenMutex.Unlock()
+		liveRoll.finishShadow()
+	}()
+
+	log.Printf("Child process on port %d (id=%s) is waiting for promotion. Run 'liveroll promote' or 'liveroll abort'",
+		child.port, child.id)
+
+	var timeout <-chan time.Time
+	if liveRoll.PromotionTimeout > 0 {
+		timer := time.NewTimer(liveRoll.PromotionTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	select {
+	case promote := <-decision:
+		if !promote {
+			return errPromotionAborted
+		}
+		log.Printf("Child process on port %d promoted", child.port)
+		return nil
+	case <-timeout:
+		return fmt.Errorf("%w: no decision within %v", errPromotionAborted, liveRoll.PromotionTimeout)
+	case <-child.exited:
+		return fmt.Errorf("child process on port %d exited while waiting for promotion", child.port)
+	case <-oldExited:
+		log.Printf("The old child process(es) exited while port %d was waiting for promotion. Promoting it", child.port)
+		return nil
+	}
+}
+
+// otherChildren returns the managed child processes other than child.
+func (liveRoll *LiveRoll) otherChildren(child *ChildProcess) []*ChildProcess {
+	liveRoll.childrenMutex.Lock()
+	defer liveRoll.childrenMutex.Unlock()
+	var children []*ChildProcess
+	for _, c := range liveRoll.children {
+		if c != child {
+			children = append(children, c)
+		}
+	}
+	return children
+}
+
+// childrenExited returns a channel closed once every child in children has exited, or nil if
+// children is empty. Watching stops when stop is closed.
+func childrenExited(children []*ChildProcess, stop <-chan struct{}) <-chan struct{} {
+	if len(children) == 0 {
+		return nil
+	}
+	done := make(chan struct{})
+	go func() {
+		for _, c := range children {
+			select {
+			case <-c.exited:
+			case <-stop:
+				return
+			}
+		}
+		close(done)
+	}()
+	return done
+}
+
+// decidePromotion promotes (promote=true) or aborts the candidate waiting for promotion.
+func (liveRoll *LiveRoll) decidePromotion(promote bool) error {
+	liveRoll.childrenMutex.Lock()
+	defer liveRoll.childrenMutex.Unlock()
+	if liveRoll.candidate == nil || liveRoll.promotionChan == nil {
+		return errNoCandidate
+	}
+	select {
+	case liveRoll.promotionChan <- promote:
+		return nil
+	default:
+		return fmt.Errorf("a decision for the candidate on port %d is already pending", liveRoll.candidate.port)
+	}
+}
+
+// candidateStatus describes the candidate waiting for promotion, if any.
+func (liveRoll *LiveRoll) candidateStatus() *SlotStatus {
+	liveRoll.childrenMutex.Lock()
+	defer liveRoll.childrenMutex.Unlock()
+	if liveRoll.candidate == nil {
+		return nil
+	}
+	slot := &SlotStatus{Port: liveRoll.candidate.port, ID: liveRoll.candidate.id, Running: true}
+	if liveRoll.candidate.cmd != nil && liveRoll.candidate.cmd.Process != nil {
+		slot.PID = liveRoll.candidate.cmd.Process.Pid
+	}
+	return slot
+}
+
+// previewHandler forwards requests on the preview port to the candidate waiting for promotion.
+func (liveRoll *LiveRoll) previewHandler(w http.ResponseWriter, req *http.Request) {
+	liveRoll.childrenMutex.Lock()
+	candidate := liveRoll.candidate
+	liveRoll.childrenMutex.Unlock()
+	if candidate == nil {
+		http.Error(w, "no candidate is waiting for promotion", http.StatusServiceUnavailable)
+		return
+	}
+
+	u, err := url.Parse(backendURLForPort(candidate.port))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	outReq := *req
+	outReq.URL = u
+	liveRoll.forwarder.ServeHTTP(w, &outReq)
+}
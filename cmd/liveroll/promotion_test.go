@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// waitForCandidate waits until a candidate is registered by waitForPromotion.
+func waitForCandidate(t *testing.T, lr *LiveRoll) {
+	t.Helper()
+	for i := 0; i < 100; i++ {
+		if lr.candidateStatus() != nil {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("Candidate was not registered")
+}
+
+// TestWaitForPromotion_Promote tests that a candidate is released by a promote command.
+func TestWaitForPromotion_Promote(t *testing.T) {
+	lr := createTestLiveRoll()
+	child := &ChildProcess{port: 9102, id: "new", exited: make(chan struct{})}
+
+	if err := lr.decidePromotion(true); !errors.Is(err, errNoCandidate) {
+		t.Errorf("Expected errNoCandidate, got: %v", err)
+	}
+
+	go func() {
+		waitForCandidate(t, lr)
+		if err := lr.decidePromotion(true); err != nil {
+			t.Errorf("Expected promote to be accepted, got: %v", err)
+		}
+	}()
+	if err := lr.waitForPromotion(child); err != nil {
+		t.Errorf("Expected candidate to be promoted, got: %v", err)
+	}
+	if lr.candidateStatus() != nil {
+		t.Error("Expected candidate to be cleared")
+	}
+}
+
+// TestWaitForPromotion_OldChildExited tests that the candidate is promoted without a command
+// when the old child process exits while it waits, so that requests are served again.
+func TestWaitForPromotion_OldChildExited(t *testing.T) {
+	lr := createTestLiveRoll()
+	old := &ChildProcess{port: 9101, id: "old", exited: make(chan struct{})}
+	lr.children[old.port] = old
+	child := &ChildProcess{port: 9102, id: "new", exited: make(chan struct{})}
+
+	go func() {
+		waitForCandidate(t, lr)
+		close(old.exited)
+	}()
+	done := make(chan error, 1)
+	go func() { done <- lr.waitForPromotion(child) }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("Expected the candidate to be promoted, got: %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the candidate to be promoted once the old child exited")
+	}
+}
+
+// TestWaitForPromotion_Abort tests abort by command and by timeout.
+func TestWaitForPromotion_Abort(t *testing.T) {
+	lr := createTestLiveRoll()
+	child := &ChildProcess{port: 9102, id: "new", exited: make(chan struct{})}
+
+	go func() {
+		waitForCandidate(t, lr)
+		_ = lr.decidePromotion(false)
+	}()
+	if err := lr.waitForPromotion(child); !errors.Is(err, errPromotionAborted) {
+		t.Errorf("Expected abort, got: %v", err)
+	}
+
+	lr.PromotionTimeout = 50 * time.Millisecond
+	if err := lr.waitForPromotion(child); !errors.Is(err, errPromotionAborted) {
+		t.Errorf("Expected abort on timeout, got: %v", err)
+	}
+}
+
+// TestPreviewHandler tests that the preview port forwards to the candidate only.
+func TestPreviewHandler(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.forwarder = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Host != "localhost:9102" {
+			t.Errorf("Expected request to be forwarded to the candidate, got %s", r.URL.Host)
+		}
+		w.WriteHeader(http.StatusTeapot)
+	})
+
+	rec := httptest.NewRecorder()
+	lr.previewHandler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Errorf("Expected 503 without candidate, got %d", rec.Code)
+	}
+
+	lr.candidate = &ChildProcess{port: 9102, id: "new"}
+	rec = httptest.NewRecorder()
+	lr.previewHandler(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("Expected request to reach the candidate, got %d", rec.Code)
+	}
+}
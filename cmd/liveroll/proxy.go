@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/vulcand/oxy/v2/utils"
+)
+
+// statusRecorder is a http.ResponseWriter that remembers the status code written by the forwarder.
+type statusRecorder struct {
+	http.ResponseWriter
+	status       int
+	networkError bool
+	// onHijack is called with the client connection when the connection is upgraded.
+	onHijack func(net.Conn)
+}
+
+func (r *statusRecorder) WriteHeader(code int) {
+	if r.status == 0 {
+		r.status = code
+	}
+	r.ResponseWriter.WriteHeader(code)
+}
+
+func (r *statusRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	return r.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher so streaming responses are not held back.
+func (r *statusRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker so upgraded connections keep working.
+func (r *statusRecorder) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if hi, ok := r.ResponseWriter.(http.Hijacker); ok {
+		conn, rw, err := hi.Hijack()
+		if err == nil && r.onHijack != nil {
+			r.onHijack(conn)
+		}
+		return conn, rw, err
+	}
+	return nil, nil, fmt.Errorf("the response writer does not implement http.Hijacker")
+}
+
+func (r *statusRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// observeBackend wraps the forwarder and records the outcome of every proxied request
+// for the backend it was sent to.
+func (liveRoll *LiveRoll) observeBackend(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		port := portFromURL(req.URL)
+		rec := &statusRecorder{ResponseWriter: w}
+
+		if isLongLived(req) {
+			// Long-lived connections are closed explicitly when their backend is drained.
+			// Their duration says nothing about the backend's latency, so only the outcome is recorded.
+			ctx, cancel := context.WithCancel(req.Context())
+			defer cancel()
+			conn := &longLivedConn{port: port, websocket: isUpgrade(req), cancel: cancel}
+			rec.onHijack = conn.setConn
+			liveRoll.longLived.add(conn)
+			defer liveRoll.longLived.remove(conn)
+			next.ServeHTTP(rec, req.WithContext(ctx))
+			liveRoll.recordBackendResult(port, rec.status, rec.networkError)
+			return
+		}
+
+		liveRoll.inFlight.add(port, 1)
+		defer liveRoll.inFlight.add(port, -1)
+		start := time.Now()
+		next.ServeHTTP(rec, req)
+		liveRoll.traffic.record(port, rec.status, rec.networkError, time.Since(start))
+		liveRoll.recordBackendResult(port, rec.status, rec.networkError)
+	})
+}
+
+// proxyErrorHandler is used by the forwarder when the backend could not be reached.
+func proxyErrorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	if rec, ok := w.(*statusRecorder); ok {
+		rec.networkError = true
+	}
+	utils.DefaultHandler.ServeHTTP(w, req, err)
+}
+
+// portFromURL returns the port number of a backend URL, or 0 if it has none.
+func portFromURL(u *url.URL) int {
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		return 0
+	}
+	return port
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"time"
+)
+
+// outcomeQuarantined is the outcome of an interval update that found a quarantined ID.
+const outcomeQuarantined = "quarantined"
+
+// QuarantinedID is an ID that failed to roll out and is skipped by interval updates until Until.
+type QuarantinedID struct {
+	ID       string    `json:"id"`
+	Reason   string    `json:"reason"`
+	Failures int       `json:"failures"`
+	FailedAt time.Time `json:"failed_at"`
+	Until    time.Time `json:"until"`
+}
+
+// quarantineFailedRollout quarantines the new ID of a completed update process that failed,
+// was rolled back or was aborted, and lifts the quarantine of a promoted ID.
+func (liveRoll *LiveRoll) quarantineFailedRollout(result *RolloutResult) {
+	if result.NewID == "" {
+		return
+	}
+	liveRoll.currentIDMutex.Lock()
+	defer liveRoll.currentIDMutex.Unlock()
+
+	switch result.Outcome {
+	case outcomePromoted:
+		delete(liveRoll.quarantine, result.NewID)
+	case outcomeFailed, outcomeRolledBack, outcomeAborted:
+		if liveRoll.QuarantineDuration <= 0 || result.NewID == liveRoll.currentID {
+			return
+		}
+		q := liveRoll.quarantine[result.NewID]
+		q.ID = result.NewID
+		q.Reason = result.Error
+		q.Failures++
+		q.FailedAt = result.FinishedAt
+		q.Until = result.FinishedAt.Add(liveRoll.QuarantineDuration)
+		liveRoll.quarantine[result.NewID] = q
+		log.Printf("Quarantined ID %s until %v: %s", q.ID, q.Until, q.Reason)
+	}
+}
+
+// quarantinedID returns the quarantine of id, if it is quarantined at now.
+func (liveRoll *LiveRoll) quarantinedID(id string, now time.Time) (QuarantinedID, bool) {
+	liveRoll.currentIDMutex.Lock()
+	defer liveRoll.currentIDMutex.Unlock()
+	q, ok := liveRoll.quarantine[id]
+	if ok && !now.Before(q.Until) {
+		delete(liveRoll.quarantine, id)
+		return QuarantinedID{}, false
+	}
+	return q, ok
+}
+
+// quarantinedIDs returns the IDs under quarantine, oldest failure first.
+func (liveRoll *LiveRoll) quarantinedIDs() []QuarantinedID {
+	now := time.Now()
+	liveRoll.currentIDMutex.Lock()
+	defer liveRoll.currentIDMutex.Unlock()
+	ids := make([]QuarantinedID, 0, len(liveRoll.quarantine))
+	for id, q := range liveRoll.quarantine {
+		if !now.Before(q.Until) {
+			delete(liveRoll.quarantine, id)
+			continue
+		}
+		ids = append(ids, q)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i].FailedAt.Before(ids[j].FailedAt) })
+	return ids
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestQuarantineFailedRollout tests that failed IDs are quarantined and released.
+func TestQuarantineFailedRollout(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.QuarantineDuration = time.Minute
+
+	result := lr.beginRollout(updateRequest{trigger: triggerInterval})
+	lr.updateRollout(func(r *RolloutResult) { r.NewID = "bad" })
+	lr.finishRollout(result, errors.New("healthcheck failed"))
+	lr.quarantineFailedRollout(result)
+
+	q, ok := lr.quarantinedID("bad", time.Now())
+	if !ok || q.Failures != 1 || q.Reason != "healthcheck failed" {
+		t.Fatalf("Expected bad to be quarantined, got %+v (%v)", q, ok)
+	}
+	if st := lr.status(); len(st.Quarantined) != 1 || st.Quarantined[0].ID != "bad" {
+		t.Errorf("Expected bad in the status, got %+v", st.Quarantined)
+	}
+	if _, ok := lr.quarantinedID("bad", q.Until); ok {
+		t.Error("Expected the quarantine to expire")
+	}
+
+	// A later successful rollout of the ID lifts the quarantine.
+	lr.quarantineFailedRollout(result)
+	lr.quarantineFailedRollout(&RolloutResult{NewID: "bad", Outcome: outcomePromoted})
+	if _, ok := lr.quarantinedID("bad", time.Now()); ok {
+		t.Error("Expected the quarantine to be lifted by a promotion")
+	}
+}
+
+// TestUpdateProcess_Quarantined tests that interval updates skip a quarantined ID.
+func TestUpdateProcess_Quarantined(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.PullCmdStr = "true"
+	lr.IdCmdStr = "echo bad"
+	lr.currentID = "good"
+	lr.quarantine["bad"] = QuarantinedID{ID: "bad", Failures: 1, Until: time.Now().Add(time.Minute)}
+
+	result := lr.beginRollout(updateRequest{trigger: triggerInterval})
+	err := lr.updateProcess(updateRequest{trigger: triggerInterval})
+	lr.finishRollout(result, err)
+	if err != nil {
+		t.Fatalf("Expected update to be skipped, got: %v", err)
+	}
+	if last := lr.lastRolloutResult(); last.Outcome != outcomeQuarantined {
+		t.Errorf("Expected outcome %q, got %q", outcomeQuarantined, last.Outcome)
+	}
+}
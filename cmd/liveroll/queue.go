@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// servingBackendsLocked returns the number of backends that receive new requests from the load balancer.
+// The caller must hold backendURLsMutex.
+func (liveRoll *LiveRoll) servingBackendsLocked() int {
+	n := 0
+	for port := range liveRoll.backendURLs {
+		if _, ejected := liveRoll.ejectedUntil(port); !ejected && liveRoll.backendWeights[port] > 0 {
+			n++
+		}
+	}
+	return n
+}
+
+// notifyBackendsChanged wakes up the requests waiting for a backend.
+// The caller must hold backendURLsMutex.
+func (liveRoll *LiveRoll) notifyBackendsChanged() {
+	close(liveRoll.backendsChanged)
+	liveRoll.backendsChanged = make(chan struct{})
+}
+
+// waitForBackend waits until a backend receives new requests, the timer fires or done is closed.
+// It reports whether a backend is available.
+func (liveRoll *LiveRoll) waitForBackend(timer <-chan time.Time, done <-chan struct{}) bool {
+	for {
+		liveRoll.backendURLsMutex.Lock()
+		serving := liveRoll.servingBackendsLocked()
+		changed := liveRoll.backendsChanged
+		liveRoll.backendURLsMutex.Unlock()
+		if serving > 0 {
+			return true
+		}
+
+		select {
+		case <-changed:
+		case <-timer:
+			return false
+		case <-done:
+			return false
+		}
+	}
+}
+
+// withQueue holds requests for up to QueueTimeout while no backend is available and
+// releases them as soon as one is added. At most QueueSize requests are held; the others,
+// and the requests whose wait expires, get 503 with Retry-After.
+func (liveRoll *LiveRoll) withQueue(next http.Handler) http.Handler {
+	retryAfter := strconv.Itoa(int(math.Max(1, math.Ceil(liveRoll.QueueTimeout.Seconds()))))
+	unavailable := func(w http.ResponseWriter, reason string) {
+		w.Header().Set("Retry-After", retryAfter)
+		http.Error(w, reason, http.StatusServiceUnavailable)
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		liveRoll.backendURLsMutex.Lock()
+		serving := liveRoll.servingBackendsLocked()
+		liveRoll.backendURLsMutex.Unlock()
+		if serving > 0 {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		if n := liveRoll.queued.Add(1); n > int64(liveRoll.QueueSize) {
+			liveRoll.queued.Add(-1)
+			log.Printf("Request queue is full (%d). Rejecting %s %s", liveRoll.QueueSize, req.Method, req.URL.Path)
+			unavailable(w, "no backend is available")
+			return
+		}
+		timer := time.NewTimer(liveRoll.QueueTimeout)
+		available := liveRoll.waitForBackend(timer.C, req.Context().Done())
+		timer.Stop()
+		liveRoll.queued.Add(-1)
+
+		if !available {
+			unavailable(w, "no backend became available")
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
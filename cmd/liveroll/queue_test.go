@@ -0,0 +1,67 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestWithQueue_Release tests that a request held while no backend is available is released
+// as soon as a backend is added.
+func TestWithQueue_Release(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t)
+	lr.QueueTimeout = 5 * time.Second
+	lr.QueueSize = 10
+	handler := lr.withQueue(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	done := make(chan int)
+	go func() {
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+		done <- rec.Code
+	}()
+
+	for i := 0; i < 100 && lr.queued.Load() == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if lr.status().Queued != 1 {
+		t.Fatal("Expected the request to be queued")
+	}
+	lr.addBackend(&ChildProcess{port: 9101})
+
+	select {
+	case code := <-done:
+		if code != http.StatusTeapot {
+			t.Errorf("Expected the request to reach the backend, got %d", code)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Expected the request to be released")
+	}
+}
+
+// TestWithQueue_Timeout tests that 503 with Retry-After is returned when the wait expires or the queue is full.
+func TestWithQueue_Timeout(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t)
+	lr.QueueTimeout = 50 * time.Millisecond
+	lr.QueueSize = 1
+	handler := lr.withQueue(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Expected the request not to reach a backend")
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || rec.Header().Get("Retry-After") != "1" {
+		t.Errorf("Expected 503 with Retry-After, got %d %q", rec.Code, rec.Header().Get("Retry-After"))
+	}
+
+	lr.queued.Store(1)
+	start := time.Now()
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusServiceUnavailable || time.Since(start) >= lr.QueueTimeout {
+		t.Errorf("Expected immediate 503 when the queue is full, got %d after %v", rec.Code, time.Since(start))
+	}
+}
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"sync"
+	"time"
+)
+
+// maxLatencySamples bounds the number of latency samples kept per backend.
+const maxLatencySamples = 1024
+
+// backendMetrics holds the proxied traffic statistics of a backend since the last reset.
+type backendMetrics struct {
+	requests  int
+	errors    int
+	latencies []time.Duration
+	next      int
+}
+
+// trafficMetrics collects proxied traffic statistics per backend (key: child process port).
+type trafficMetrics struct {
+	mutex    sync.Mutex
+	backends map[int]*backendMetrics
+}
+
+func newTrafficMetrics() *trafficMetrics {
+	return &trafficMetrics{backends: make(map[int]*backendMetrics)}
+}
+
+// record adds the result of a proxied request to the statistics of the backend.
+func (t *trafficMetrics) record(port int, status int, networkError bool, latency time.Duration) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	m, ok := t.backends[port]
+	if !ok {
+		m = &backendMetrics{}
+		t.backends[port] = m
+	}
+	m.requests++
+	if networkError || status >= 500 {
+		m.errors++
+	}
+	if len(m.latencies) < maxLatencySamples {
+		m.latencies = append(m.latencies, latency)
+	} else {
+		m.latencies[m.next] = latency
+		m.next = (m.next + 1) % maxLatencySamples
+	}
+}
+
+// reset discards all statistics.
+func (t *trafficMetrics) reset() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.backends = make(map[int]*backendMetrics)
+}
+
+// summary aggregates the statistics of the given backends.
+func (t *trafficMetrics) summary(ports []int, percentile float64) TrafficSummary {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	var s TrafficSummary
+	var latencies []time.Duration
+	for _, port := range ports {
+		if m, ok := t.backends[port]; ok {
+			s.Requests += m.requests
+			s.Errors += m.errors
+			latencies = append(latencies, m.latencies...)
+		}
+	}
+	if s.Requests > 0 {
+		s.ErrorRate = float64(s.Errors) / float64(s.Requests)
+	}
+	s.Latency = latencyPercentile(latencies, percentile)
+	return s
+}
+
+// latencyPercentile returns the p-th percentile (0-100) of the samples.
+func latencyPercentile(samples []time.Duration, p float64) time.Duration {
+	if len(samples) == 0 {
+		return 0
+	}
+	sorted := append([]time.Duration(nil), samples...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	idx := int(math.Ceil(p/100*float64(len(sorted)))) - 1
+	if idx < 0 {
+		idx = 0
+	}
+	if idx >= len(sorted) {
+		idx = len(sorted) - 1
+	}
+	return sorted[idx]
+}
+
+// TrafficSummary is the aggregated proxied traffic of one version.
+type TrafficSummary struct {
+	Requests  int           `json:"requests"`
+	Errors    int           `json:"errors"`
+	ErrorRate float64       `json:"error_rate"`
+	Latency   time.Duration `json:"latency"`
+}
+
+// TrafficComparison describes the comparison between the new and the old version during a bake period
+// and the decision made from it.
+type TrafficComparison struct {
+	Percentile float64        `json:"percentile"`
+	New        TrafficSummary `json:"new"`
+	Old        TrafficSummary `json:"old"`
+	Decision   string         `json:"decision"`
+	Reason     string         `json:"reason,omitempty"`
+}
+
+const (
+	decisionPromote  = "promote"
+	decisionRollback = "rollback"
+)
+
+// regressionError is returned when the new version performs worse than the old one.
+type regressionError struct {
+	comparison *TrafficComparison
+}
+
+func (e *regressionError) Error() string {
+	return "regression detected: " + e.comparison.Reason
+}
+
+// regressionCheckEnabled reports whether any rollback threshold is configured.
+func (liveRoll *LiveRoll) regressionCheckEnabled() bool {
+	return liveRoll.RollbackErrorRateIncrease > 0 || liveRoll.RollbackLatencyRatio > 0
+}
+
+// compareTraffic compares the proxied traffic of the new backend with the old backends.
+// The decision is decisionRollback only if there is enough traffic and a threshold is exceeded.
+func (liveRoll *LiveRoll) compareTraffic(newPort int, oldPorts []int) *TrafficComparison {
+	c := &TrafficComparison{
+		Percentile: liveRoll.RollbackLatencyPercentile,
+		New:        liveRoll.traffic.summary([]int{newPort}, liveRoll.RollbackLatencyPercentile),
+		Old:        liveRoll.traffic.summary(oldPorts, liveRoll.RollbackLatencyPercentile),
+		Decision:   decisionPromote,
+	}
+	if c.New.Requests < liveRoll.RollbackMinRequests || c.Old.Requests < liveRoll.RollbackMinRequests {
+		return c
+	}
+
+	if liveRoll.RollbackErrorRateIncrease > 0 && c.New.ErrorRate-c.Old.ErrorRate > liveRoll.RollbackErrorRateIncrease {
+		c.Decision = decisionRollback
+		c.Reason = fmt.Sprintf("error rate %.3f exceeds old error rate %.3f by more than %.3f",
+			c.New.ErrorRate, c.Old.ErrorRate, liveRoll.RollbackErrorRateIncrease)
+	} else if liveRoll.RollbackLatencyRatio > 0 && c.Old.Latency > 0 &&
+		float64(c.New.Latency) > float64(c.Old.Latency)*liveRoll.RollbackLatencyRatio {
+		c.Decision = decisionRollback
+		c.Reason = fmt.Sprintf("p%v latency %v exceeds %.2f times old latency %v",
+			c.Percentile, c.New.Latency, liveRoll.RollbackLatencyRatio, c.Old.Latency)
+	}
+	return c
+}
+
+// logTrafficComparison logs the decision and the metrics behind it.
+func logTrafficComparison(c *TrafficComparison) {
+	log.Printf("Traffic comparison (decision=%s): new requests=%d errors=%d p%v=%v, old requests=%d errors=%d p%v=%v %s",
+		c.Decision,
+		c.New.Requests, c.New.Errors, c.Percentile, c.New.Latency,
+		c.Old.Requests, c.Old.Errors, c.Percentile, c.Old.Latency,
+		c.Reason)
+}
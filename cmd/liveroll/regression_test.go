@@ -0,0 +1,98 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// TestLatencyPercentile tests the percentile calculation of latency samples.
+func TestLatencyPercentile(t *testing.T) {
+	var samples []time.Duration
+	for i := 100; i >= 1; i-- {
+		samples = append(samples, time.Duration(i)*time.Millisecond)
+	}
+	if got := latencyPercentile(samples, 99); got != 99*time.Millisecond {
+		t.Errorf("Expected p99 of 99ms, got %v", got)
+	}
+	if got := latencyPercentile(samples, 50); got != 50*time.Millisecond {
+		t.Errorf("Expected p50 of 50ms, got %v", got)
+	}
+	if got := latencyPercentile(nil, 99); got != 0 {
+		t.Errorf("Expected 0 for no samples, got %v", got)
+	}
+}
+
+// TestCompareTraffic tests the rollback decision based on error rate and latency.
+func TestCompareTraffic(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.RollbackErrorRateIncrease = 0.1
+	lr.RollbackLatencyRatio = 2
+	lr.RollbackLatencyPercentile = 99
+	lr.RollbackMinRequests = 10
+
+	for i := 0; i < 10; i++ {
+		lr.traffic.record(9101, http.StatusOK, false, 10*time.Millisecond)
+		lr.traffic.record(9102, http.StatusOK, false, 12*time.Millisecond)
+	}
+	if c := lr.compareTraffic(9102, []int{9101}); c.Decision != decisionPromote {
+		t.Errorf("Expected promote, got %+v", c)
+	}
+
+	for i := 0; i < 5; i++ {
+		lr.traffic.record(9102, http.StatusInternalServerError, false, 12*time.Millisecond)
+	}
+	if c := lr.compareTraffic(9102, []int{9101}); c.Decision != decisionRollback || c.New.Errors != 5 {
+		t.Errorf("Expected rollback on error rate, got %+v", c)
+	}
+
+	lr.traffic.reset()
+	for i := 0; i < 10; i++ {
+		lr.traffic.record(9101, http.StatusOK, false, 10*time.Millisecond)
+		lr.traffic.record(9102, http.StatusOK, false, 50*time.Millisecond)
+	}
+	if c := lr.compareTraffic(9102, []int{9101}); c.Decision != decisionRollback {
+		t.Errorf("Expected rollback on latency, got %+v", c)
+	}
+
+	lr.RollbackMinRequests = 100
+	if c := lr.compareTraffic(9102, []int{9101}); c.Decision != decisionPromote {
+		t.Errorf("Expected promote without enough requests, got %+v", c)
+	}
+}
+
+// TestBakeRollout_Regression tests that a regressing child is removed and the rollout is recorded as rolled back.
+func TestBakeRollout_Regression(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t, 9101)
+	lr.BakeTime = 2 * time.Second
+	lr.RollbackErrorRateIncrease = 0.1
+	lr.RollbackLatencyPercentile = 99
+	lr.RollbackMinRequests = 1
+
+	child := &ChildProcess{port: 9102, id: "new"}
+	lr.children[9101] = &ChildProcess{port: 9101, id: "old"}
+	lr.children[9102] = child
+
+	result := lr.beginRollout(updateRequest{})
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		lr.traffic.record(9101, http.StatusOK, false, time.Millisecond)
+		lr.traffic.record(9102, http.StatusBadGateway, true, time.Millisecond)
+	}()
+	err := lr.bakeRollout(child)
+	var regErr *regressionError
+	if !errors.As(err, &regErr) {
+		t.Fatalf("Expected regression error, got: %v", err)
+	}
+	if _, ok := lr.backendWeight(9102); ok {
+		t.Error("Expected new backend to be removed from the load balancer")
+	}
+
+	lr.finishRollout(result, fmt.Errorf("bake failed: %w", err))
+	last := lr.lastRolloutResult()
+	if last.Outcome != outcomeRolledBack || last.Comparison == nil || last.Comparison.New.Errors != 1 {
+		t.Errorf("Unexpected rollout result: %+v", last)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// triedBackendsKey is the context key of the ports a retried request was already sent to.
+type triedBackendsKey struct{}
+
+// parseRetryStatuses parses a comma separated list of 5xx status codes, e.g. "503,504".
+func parseRetryStatuses(s string) ([]int, error) {
+	if strings.TrimSpace(s) == "" {
+		return nil, nil
+	}
+	var codes []int
+	for _, field := range strings.Split(s, ",") {
+		code, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil {
+			return nil, fmt.Errorf("invalid retry status %q: %v", field, err)
+		}
+		if code < 500 || code > 599 {
+			return nil, fmt.Errorf("retry status must be a 5xx code: %d", code)
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+// retryPredicate returns the oxy buffer expression deciding whether a response is retried:
+// network errors and RetryStatuses, as long as fewer than RetryAttempts attempts were made.
+func (liveRoll *LiveRoll) retryPredicate() string {
+	conditions := []string{"IsNetworkError()"}
+	for _, code := range liveRoll.RetryStatuses {
+		conditions = append(conditions, fmt.Sprintf("ResponseCode() == %d", code))
+	}
+	return fmt.Sprintf("Attempts() < %d && (%s)", liveRoll.RetryAttempts, strings.Join(conditions, " || "))
+}
+
+// isIdempotent reports whether req can be sent again without side effects,
+// following the rules of net/http's transport.
+func isIdempotent(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	_, ok := req.Header["Idempotency-Key"]
+	if !ok {
+		_, ok = req.Header["X-Idempotency-Key"]
+	}
+	return ok
+}
+
+// isRetryable reports whether req may be retried by the buffer handler.
+func (liveRoll *LiveRoll) isRetryable(req *http.Request) bool {
+	return liveRoll.RetryAttempts > 1 && (!liveRoll.RetryIdempotentOnly || isIdempotent(req))
+}
+
+// avoidTriedBackends sends a retried request to a backend it was not sent to yet, if there is
+// one, instead of the backend picked by the load balancer. The sticky cookie follows the request.
+func (liveRoll *LiveRoll) avoidTriedBackends(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// Attempts are made one after another, so the map needs no lock.
+		tried, ok := req.Context().Value(triedBackendsKey{}).(map[int]bool)
+		if !ok {
+			next.ServeHTTP(w, req)
+			return
+		}
+		port := portFromURL(req.URL)
+		if tried[port] {
+			if u := liveRoll.untriedBackend(tried); u != nil {
+				outReq := *req
+				outReq.URL = u
+				req = &outReq
+				port = portFromURL(u)
+				liveRoll.stickTo(w, u)
+			}
+		}
+		tried[port] = true
+		next.ServeHTTP(w, req)
+	})
+}
+
+// untriedBackend returns the URL of a backend receiving new requests whose port is not in
+// tried, or nil if there is none.
+func (liveRoll *LiveRoll) untriedBackend(tried map[int]bool) *url.URL {
+	liveRoll.backendURLsMutex.Lock()
+	defer liveRoll.backendURLsMutex.Unlock()
+	var ports []int
+	for port := range liveRoll.backendURLs {
+		if _, ejected := liveRoll.ejectedUntil(port); !ejected && liveRoll.backendWeights[port] > 0 && !tried[port] {
+			ports = append(ports, port)
+		}
+	}
+	if len(ports) == 0 {
+		return nil
+	}
+	sort.Ints(ports)
+	return liveRoll.backendURLs[ports[0]]
+}
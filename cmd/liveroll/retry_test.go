@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/vulcand/oxy/v2/roundrobin/stickycookie"
+)
+
+// TestRetry_Policy tests that idempotent requests failing with a retried status are sent to the
+// other backend, and that other requests are not retried.
+func TestRetry_Policy(t *testing.T) {
+	var failing atomic.Int64
+	failingBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		failing.Add(1)
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failingBackend.Close()
+	okBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+	defer okBackend.Close()
+
+	lr := createTestLiveRoll()
+	lr.RetryAttempts = 2
+	lr.RetryIdempotentOnly = true
+	lr.RetryStatuses = []int{http.StatusServiceUnavailable}
+	_, proxyURL := createTestProxy(t, lr, failingBackend)
+	lr.addBackend(childForServer(t, okBackend))
+
+	for i := 0; i < 4; i++ {
+		resp, err := http.Get(proxyURL)
+		if err != nil {
+			t.Fatalf("Failed to request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			t.Errorf("Expected GET to be retried on the other backend, got %d", resp.StatusCode)
+		}
+	}
+
+	failed := 0
+	for i := 0; i < 4; i++ {
+		resp, err := http.Post(proxyURL, "text/plain", strings.NewReader("x"))
+		if err != nil {
+			t.Fatalf("Failed to request: %v", err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusServiceUnavailable {
+			failed++
+		}
+	}
+	if failed == 0 {
+		t.Error("Expected POST not to be retried")
+	}
+}
+
+// TestAvoidTriedBackends tests that a retried request is sent to a backend it was not sent to yet,
+// and to the backend picked by the load balancer once every backend was tried.
+func TestAvoidTriedBackends(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t, 9101, 9102)
+
+	var ports []int
+	handler := lr.avoidTriedBackends(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ports = append(ports, portFromURL(r.URL))
+	}))
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:9101/", nil)
+	tried := map[int]bool{}
+	req = req.WithContext(context.WithValue(req.Context(), triedBackendsKey{}, tried))
+	for i := 0; i < 3; i++ {
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+	}
+	if want := []int{9101, 9102, 9101}; !slices.Equal(ports, want) {
+		t.Errorf("Expected attempts on ports %v, got %v", want, ports)
+	}
+}
+
+// TestAvoidTriedBackends_StickyCookie tests that the sticky cookie of a rerouted request points
+// to the backend serving it.
+func TestAvoidTriedBackends_StickyCookie(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t, 9101, 9102)
+	lr.StickyCookie = "liveroll"
+	lr.loadBalancerOptions()
+
+	handler := lr.avoidTriedBackends(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	req := httptest.NewRequest(http.MethodGet, "http://localhost:9101/", nil)
+	req = req.WithContext(context.WithValue(req.Context(), triedBackendsKey{}, map[int]bool{9101: true}))
+	rec := httptest.NewRecorder()
+	// The cookie set by the load balancer for the backend it picked.
+	lr.stickySession.StickBackend(req.URL, rec)
+	http.SetCookie(rec, &http.Cookie{Name: "other", Value: "1"})
+	handler.ServeHTTP(rec, req)
+
+	want := (&stickycookie.HashValue{}).Get(lr.backendURLs[9102])
+	var values []string
+	for _, cookie := range rec.Result().Cookies() {
+		if cookie.Name == lr.StickyCookie {
+			values = append(values, cookie.Value)
+		} else if cookie.Name != "other" {
+			t.Errorf("Unexpected cookie %q", cookie.Name)
+		}
+	}
+	if !slices.Equal(values, []string{want}) {
+		t.Errorf("Expected the sticky cookie %q for port 9102, got %v", want, values)
+	}
+}
+
+// TestRetryPredicate tests the expression built for oxy's buffer handler.
+func TestRetryPredicate(t *testing.T) {
+	if _, err := parseRetryStatuses("503,404"); err == nil {
+		t.Error("Expected error for a status other than 5xx")
+	}
+	codes, err := parseRetryStatuses("503, 504")
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	lr := createTestLiveRoll()
+	lr.RetryAttempts = 3
+	lr.RetryStatuses = codes
+	want := "Attempts() < 3 && (IsNetworkError() || ResponseCode() == 503 || ResponseCode() == 504)"
+	if got := lr.retryPredicate(); got != want {
+		t.Errorf("Expected %q, got %q", want, got)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"log"
+	"time"
+)
+
+const (
+	outcomePromoted   = "promoted"
+	outcomeUnchanged  = "unchanged"
+	outcomeFailed     = "failed"
+	outcomeRolledBack = "rolled_back"
+	outcomeAborted    = "aborted"
+	outcomeSkipped    = "skipped"
+)
+
+// RolloutResult records the outcome of an update process.
+type RolloutResult struct {
+	StartedAt  time.Time          `json:"started_at"`
+	FinishedAt time.Time          `json:"finished_at"`
+	Trigger    string             `json:"trigger,omitempty"`
+	Forced     bool               `json:"forced"`
+	OldID      string             `json:"old_id"`
+	NewID      string             `json:"new_id,omitempty"`
+	Outcome    string             `json:"outcome"`
+	Error      string             `json:"error,omitempty"`
+	Comparison *TrafficComparison `json:"comparison,omitempty"`
+	Shadow     *ShadowSummary     `json:"shadow,omitempty"`
+	Phases     []PhaseTiming      `json:"phases,omitempty"`
+}
+
+// beginRollout starts recording the result of an update process.
+func (liveRoll *LiveRoll) beginRollout(req updateRequest) *RolloutResult {
+	liveRoll.currentIDMutex.Lock()
+	current := liveRoll.currentID
+	liveRoll.currentIDMutex.Unlock()
+
+	result := &RolloutResult{
+		StartedAt: time.Now(),
+		Trigger:   req.trigger,
+		Forced:    req.forced,
+		OldID:     current,
+	}
+	liveRoll.rolloutMutex.Lock()
+	liveRoll.rollout = result
+	liveRoll.rolloutMutex.Unlock()
+	return result
+}
+
+// updateRollout applies fn to the result of the running update process.
+func (liveRoll *LiveRoll) updateRollout(fn func(r *RolloutResult)) {
+	liveRoll.rolloutMutex.Lock()
+	defer liveRoll.rolloutMutex.Unlock()
+	if liveRoll.rollout != nil {
+		fn(liveRoll.rollout)
+	}
+}
+
+// recordComparison attaches the traffic comparison of the bake period to the current rollout.
+func (liveRoll *LiveRoll) recordComparison(c *TrafficComparison) {
+	liveRoll.updateRollout(func(r *RolloutResult) { r.Comparison = c })
+}
+
+// finishRollout completes the result of the update process with its error, if any.
+func (liveRoll *LiveRoll) finishRollout(result *RolloutResult, err error) {
+	liveRoll.rolloutMutex.Lock()
+
+	result.FinishedAt = time.Now()
+	if err != nil {
+		result.Error = err.Error()
+		result.Outcome = outcomeFailed
+		var regErr *regressionError
+		if errors.As(err, &regErr) {
+			result.Outcome = outcomeRolledBack
+			result.Comparison = regErr.comparison
+		} else if errors.Is(err, errPromotionAborted) {
+			result.Outcome = outcomeAborted
+		}
+	} else if result.Outcome == "" {
+		result.Outcome = outcomePromoted
+	}
+	log.Printf("Rollout finished: trigger=%s outcome=%s old_id=%s new_id=%s duration=%v",
+		result.Trigger, result.Outcome, result.OldID, result.NewID, result.FinishedAt.Sub(result.StartedAt))
+
+	liveRoll.rollout = nil
+	liveRoll.lastRollout = result
+	completed := *result
+	liveRoll.rolloutMutex.Unlock()
+
+	liveRoll.appendHistory(completed)
+}
+
+// lastRolloutResult returns a copy of the result of the last completed update process.
+func (liveRoll *LiveRoll) lastRolloutResult() *RolloutResult {
+	liveRoll.rolloutMutex.Lock()
+	defer liveRoll.rolloutMutex.Unlock()
+	if liveRoll.lastRollout == nil {
+		return nil
+	}
+	result := *liveRoll.lastRollout
+	return &result
+}
+
+// bakeRollout adds the new child process to the load balancer next to the old backends and,
+// if BakeTime is set, bakes before the old backends are removed.
+func (liveRoll *LiveRoll) bakeRollout(child *ChildProcess) error {
+	oldPorts := liveRoll.otherBackendPorts(child.port)
+	liveRoll.traffic.reset()
+	liveRoll.addBackend(child)
+	if liveRoll.BakeTime <= 0 || len(oldPorts) == 0 {
+		return nil
+	}
+
+	log.Printf("Baking port %d next to the old backends for %v", child.port, liveRoll.BakeTime)
+	if err := liveRoll.bake(child, oldPorts, liveRoll.BakeTime); err != nil {
+		log.Printf("Bake failed: %v. Removing port %d from the load balancer", err, child.port)
+		liveRoll.removeBackend(child)
+		return err
+	}
+	return nil
+}
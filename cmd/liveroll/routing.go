@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// routingSelector describes the child process a request asks to be routed to.
+type routingSelector struct {
+	// id of the child process, if any
+	id string
+	// newest selects the most recently started child process
+	newest bool
+}
+
+// headerOrCookie returns the value of the header or cookie of req, skipping empty names.
+func headerOrCookie(req *http.Request, header string, cookie string) string {
+	if header != "" {
+		if v := strings.TrimSpace(req.Header.Get(header)); v != "" {
+			return v
+		}
+	}
+	if cookie != "" {
+		if c, err := req.Cookie(cookie); err == nil && c.Value != "" {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+// selectorFor returns the child process selected by the routing headers and cookies of req.
+// An ID takes precedence over the newest child. ok is false if req selects none.
+func (liveRoll *LiveRoll) selectorFor(req *http.Request) (sel routingSelector, ok bool) {
+	if id := headerOrCookie(req, liveRoll.RouteHeader, liveRoll.RouteCookie); id != "" {
+		return routingSelector{id: id}, true
+	}
+	if headerOrCookie(req, liveRoll.RouteNewestHeader, liveRoll.RouteNewestCookie) != "" {
+		return routingSelector{newest: true}, true
+	}
+	return routingSelector{}, false
+}
+
+// routeTarget returns the child process matching sel: the child with the ID, or the newest child.
+// The candidate waiting for promotion is included, while draining and ejected children are not.
+// Returns nil when no child matches.
+func (liveRoll *LiveRoll) routeTarget(sel routingSelector) *ChildProcess {
+	liveRoll.childrenMutex.Lock()
+	defer liveRoll.childrenMutex.Unlock()
+
+	children := make([]*ChildProcess, 0, len(liveRoll.children)+1)
+	liveRoll.backendURLsMutex.Lock()
+	for port, child := range liveRoll.children {
+		if _, ejected := liveRoll.ejectedUntil(port); ejected || liveRoll.draining[port] == child {
+			continue
+		}
+		children = append(children, child)
+	}
+	liveRoll.backendURLsMutex.Unlock()
+	if liveRoll.candidate != nil {
+		children = append(children, liveRoll.candidate)
+	}
+
+	if sel.newest {
+		var newest *ChildProcess
+		for _, child := range children {
+			if newest == nil || child.startedAt.After(newest.startedAt) {
+				newest = child
+			}
+		}
+		return newest
+	}
+	for _, child := range children {
+		if child.id == sel.id {
+			return child
+		}
+	}
+	return nil
+}
+
+// withRouting sends requests carrying a routing header or cookie to the selected child process,
+// bypassing the load balancer. Other requests, and requests naming an unknown ID, are passed to next.
+func (liveRoll *LiveRoll) withRouting(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		sel, ok := liveRoll.selectorFor(req)
+		if !ok {
+			next.ServeHTTP(w, req)
+			return
+		}
+		child := liveRoll.routeTarget(sel)
+		if child == nil {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		u, err := url.Parse(backendURLForPort(child.port))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		outReq := *req
+		outReq.URL = u
+		liveRoll.forwarder.ServeHTTP(w, &outReq)
+	})
+}
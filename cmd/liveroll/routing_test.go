@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestWithRouting tests that requests carrying the routing header or cookie bypass the load balancer.
+func TestWithRouting(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.RouteHeader = "X-Liveroll-Version"
+	lr.RouteCookie = "version"
+	lr.RouteNewestCookie = "canary"
+	now := time.Now()
+	lr.children[9101] = &ChildProcess{port: 9101, id: "old", startedAt: now.Add(-time.Minute)}
+	lr.children[9102] = &ChildProcess{port: 9102, id: "new", startedAt: now}
+	lr.children[9104] = &ChildProcess{port: 9104, id: "1", startedAt: now.Add(-2 * time.Minute)}
+
+	var forwardedTo string
+	lr.forwarder = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedTo = r.URL.Host
+	})
+	handler := lr.withRouting(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwardedTo = "lb"
+	}))
+
+	tests := []struct {
+		name   string
+		header string
+		cookie string
+		newest string
+		want   string
+	}{
+		{"no routing", "", "", "", "lb"},
+		{"header with ID", "old", "", "", "localhost:9101"},
+		{"cookie with ID", "", "new", "", "localhost:9102"},
+		{"ID looking like a flag", "1", "", "", "localhost:9104"},
+		{"newest cookie", "", "", "1", "localhost:9102"},
+		{"ID before newest", "old", "", "1", "localhost:9101"},
+		{"unknown ID", "missing", "", "", "lb"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				req.Header.Set(lr.RouteHeader, tt.header)
+			}
+			if tt.cookie != "" {
+				req.AddCookie(&http.Cookie{Name: lr.RouteCookie, Value: tt.cookie})
+			}
+			if tt.newest != "" {
+				req.AddCookie(&http.Cookie{Name: lr.RouteNewestCookie, Value: tt.newest})
+			}
+			forwardedTo = ""
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+			if forwardedTo != tt.want {
+				t.Errorf("Expected request to reach %s, got %s", tt.want, forwardedTo)
+			}
+		})
+	}
+
+	// The candidate waiting for promotion can be reached by its ID.
+	lr.candidate = &ChildProcess{port: 9103, id: "candidate", startedAt: now.Add(time.Minute)}
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	req.AddCookie(&http.Cookie{Name: lr.RouteNewestCookie, Value: "1"})
+	handler.ServeHTTP(httptest.NewRecorder(), req)
+	if forwardedTo != "localhost:9103" {
+		t.Errorf("Expected request to reach the candidate, got %s", forwardedTo)
+	}
+
+	// Draining and ejected children are not routed to.
+	lr.candidate = nil
+	lr.draining[9102] = lr.children[9102]
+	lr.ejected[backendURLForPort(9101)] = now.Add(time.Minute)
+	for _, id := range []string{"new", "old"} {
+		req = httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set(lr.RouteHeader, id)
+		handler.ServeHTTP(httptest.NewRecorder(), req)
+		if forwardedTo != "lb" {
+			t.Errorf("Expected request for %s to reach the load balancer, got %s", id, forwardedTo)
+		}
+	}
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+)
+
+// outcomeDeferred is the outcome of an interval update that found a new ID outside the rollout windows.
+const outcomeDeferred = "deferred"
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday,
+	"mon": time.Monday,
+	"tue": time.Tuesday,
+	"wed": time.Wednesday,
+	"thu": time.Thursday,
+	"fri": time.Friday,
+	"sat": time.Saturday,
+}
+
+// rolloutWindow is a weekly time window, e.g. "Mon-Fri 09:00-17:00".
+// A window whose end is not after its start crosses midnight into the next day.
+type rolloutWindow struct {
+	days  [7]bool
+	start int // minutes since midnight
+	end   int // minutes since midnight, exclusive
+}
+
+// parseRolloutWindow parses "DAYS HH:MM-HH:MM", where DAYS is "*" or a comma separated
+// list of weekdays and weekday ranges, e.g. "Mon-Fri" or "Sat,Sun".
+func parseRolloutWindow(s string) (rolloutWindow, error) {
+	var w rolloutWindow
+	fields := strings.Fields(s)
+	if len(fields) != 2 {
+		return w, fmt.Errorf("invalid window %q: expected \"DAYS HH:MM-HH:MM\"", s)
+	}
+
+	if fields[0] == "*" {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	} else {
+		for _, part := range strings.Split(fields[0], ",") {
+			from, to, isRange := strings.Cut(part, "-")
+			first, ok := weekdays[strings.ToLower(from)]
+			if !ok {
+				return w, fmt.Errorf("invalid window %q: unknown weekday %q", s, from)
+			}
+			last := first
+			if isRange {
+				if last, ok = weekdays[strings.ToLower(to)]; !ok {
+					return w, fmt.Errorf("invalid window %q: unknown weekday %q", s, to)
+				}
+			}
+			for d := first; ; d = (d + 1) % 7 {
+				w.days[d] = true
+				if d == last {
+					break
+				}
+			}
+		}
+	}
+
+	from, to, ok := strings.Cut(fields[1], "-")
+	if !ok {
+		return w, fmt.Errorf("invalid window %q: expected a time range HH:MM-HH:MM", s)
+	}
+	var err error
+	if w.start, err = parseClock(from); err != nil {
+		return w, fmt.Errorf("invalid window %q: %v", s, err)
+	}
+	if w.end, err = parseClock(to); err != nil {
+		return w, fmt.Errorf("invalid window %q: %v", s, err)
+	}
+	return w, nil
+}
+
+// parseClock parses "HH:MM" into minutes since midnight. "24:00" is accepted as the end of the day.
+func parseClock(s string) (int, error) {
+	var h, m int
+	if _, err := fmt.Sscanf(s, "%d:%d", &h, &m); err != nil || len(s) != 5 {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	if h < 0 || m < 0 || m > 59 || h > 24 || (h == 24 && m != 0) {
+		return 0, fmt.Errorf("invalid time %q", s)
+	}
+	return h*60 + m, nil
+}
+
+// contains reports whether t is within the window.
+func (w rolloutWindow) contains(t time.Time) bool {
+	m := t.Hour()*60 + t.Minute()
+	day := t.Weekday()
+	if w.start < w.end {
+		return w.days[day] && m >= w.start && m < w.end
+	}
+	// The window crosses midnight: it started either today or yesterday.
+	return (w.days[day] && m >= w.start) || (w.days[(day+6)%7] && m < w.end)
+}
+
+// rolloutAllowed reports whether interval-triggered updates may promote a new ID at t:
+// t must be in one of the rollout windows (if any) and in none of the blackout windows.
+func (liveRoll *LiveRoll) rolloutAllowed(t time.Time) bool {
+	if liveRoll.RolloutLocation != nil {
+		t = t.In(liveRoll.RolloutLocation)
+	}
+	for _, w := range liveRoll.RolloutBlackouts {
+		if w.contains(t) {
+			return false
+		}
+	}
+	if len(liveRoll.RolloutWindows) == 0 {
+		return true
+	}
+	for _, w := range liveRoll.RolloutWindows {
+		if w.contains(t) {
+			return true
+		}
+	}
+	return false
+}
+
+// nextRolloutTime returns the next minute at which rollouts are allowed after t,
+// or the zero time if there is none within a week. Whether rollouts are allowed only
+// changes when a rollout window starts or a blackout window ends, so only those
+// minutes are checked.
+func (liveRoll *LiveRoll) nextRolloutTime(t time.Time) time.Time {
+	first := t.Truncate(time.Minute).Add(time.Minute)
+	if liveRoll.rolloutAllowed(first) {
+		return first
+	}
+	last := first.Add(7 * 24 * time.Hour)
+
+	loc := t.Location()
+	if liveRoll.RolloutLocation != nil {
+		loc = liveRoll.RolloutLocation
+	}
+	var minutes []int
+	for _, w := range liveRoll.RolloutWindows {
+		minutes = append(minutes, w.start)
+	}
+	for _, w := range liveRoll.RolloutBlackouts {
+		minutes = append(minutes, w.end)
+	}
+	var candidates []time.Time
+	day := first.In(loc)
+	for i := 0; i <= 8; i++ {
+		for _, m := range minutes {
+			c := time.Date(day.Year(), day.Month(), day.Day()+i, m/60, m%60, 0, 0, loc)
+			if c.After(first) && !c.After(last) {
+				candidates = append(candidates, c)
+			}
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].Before(candidates[j]) })
+	for _, c := range candidates {
+		if liveRoll.rolloutAllowed(c) {
+			return c.In(t.Location())
+		}
+	}
+	return time.Time{}
+}
+
+// deferRollout remembers an ID that was found outside the rollout windows.
+func (liveRoll *LiveRoll) deferRollout(id string) {
+	liveRoll.currentIDMutex.Lock()
+	if id != liveRoll.deferredID {
+		if opensAt := liveRoll.nextRolloutTime(time.Now()); opensAt.IsZero() {
+			log.Printf("New ID %s is available but rollouts are not allowed now. Deferring it", id)
+		} else {
+			log.Printf("New ID %s is available but rollouts are not allowed now. Deferring it until %v", id, opensAt)
+		}
+	}
+	liveRoll.deferredID = id
+	liveRoll.currentIDMutex.Unlock()
+	liveRoll.updateRollout(func(r *RolloutResult) { r.Outcome = outcomeDeferred })
+}
+
+// deferredRolloutID returns the ID waiting for the rollout window to open, if any.
+func (liveRoll *LiveRoll) deferredRolloutID() string {
+	liveRoll.currentIDMutex.Lock()
+	defer liveRoll.currentIDMutex.Unlock()
+	return liveRoll.deferredID
+}
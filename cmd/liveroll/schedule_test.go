@@ -0,0 +1,134 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// TestParseRolloutWindow tests the parsing of rollout windows and which times they contain.
+func TestParseRolloutWindow(t *testing.T) {
+	// 2026-10-19 is a Monday.
+	at := func(day int, clock string) time.Time {
+		tm, err := time.Parse("2006-01-02 15:04", "2026-10-"+clock[:2]+" "+clock[3:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tm.AddDate(0, 0, day)
+	}
+
+	tests := []struct {
+		window string
+		in     []time.Time
+		out    []time.Time
+	}{
+		{"Mon-Fri 09:00-17:00", []time.Time{at(0, "19 09:00"), at(4, "19 16:59")}, []time.Time{at(0, "19 17:00"), at(5, "19 10:00")}},
+		{"Sat,sun 10:00-12:00", []time.Time{at(5, "19 10:30"), at(6, "19 11:59")}, []time.Time{at(0, "19 10:30")}},
+		{"Fri-Mon 22:00-02:00", []time.Time{at(4, "19 23:00"), at(0, "19 01:00")}, []time.Time{at(2, "19 01:00"), at(4, "19 21:59")}},
+		{"* 00:00-24:00", []time.Time{at(2, "19 00:00"), at(3, "19 23:59")}, nil},
+	}
+	for _, tt := range tests {
+		w, err := parseRolloutWindow(tt.window)
+		if err != nil {
+			t.Errorf("Failed to parse %q: %v", tt.window, err)
+			continue
+		}
+		for _, tm := range tt.in {
+			if !w.contains(tm) {
+				t.Errorf("Expected %q to contain %v", tt.window, tm)
+			}
+		}
+		for _, tm := range tt.out {
+			if w.contains(tm) {
+				t.Errorf("Expected %q not to contain %v", tt.window, tm)
+			}
+		}
+	}
+
+	for _, s := range []string{"", "Mon", "Mon 9-17", "Foo 09:00-17:00", "Mon-Fri 09:00-25:00", "Mon 09:60-10:00"} {
+		if _, err := parseRolloutWindow(s); err == nil {
+			t.Errorf("Expected error for %q", s)
+		}
+	}
+}
+
+// TestRolloutAllowed tests rollout windows combined with blackouts and the next opening time.
+func TestRolloutAllowed(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.RolloutLocation = time.UTC
+	window, _ := parseRolloutWindow("Mon-Fri 09:00-17:00")
+	blackout, _ := parseRolloutWindow("Mon 12:00-13:00")
+	lr.RolloutWindows = []rolloutWindow{window}
+	lr.RolloutBlackouts = []rolloutWindow{blackout}
+
+	monday := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	if !lr.rolloutAllowed(monday.Add(10 * time.Hour)) {
+		t.Error("Expected rollouts to be allowed on Monday 10:00")
+	}
+	if lr.rolloutAllowed(monday.Add(12*time.Hour + 30*time.Minute)) {
+		t.Error("Expected rollouts to be blocked during the blackout")
+	}
+	if next := lr.nextRolloutTime(monday.Add(12*time.Hour + 30*time.Minute)); !next.Equal(monday.Add(13 * time.Hour)) {
+		t.Errorf("Expected the window to open at 13:00, got %v", next)
+	}
+	if next := lr.nextRolloutTime(monday.Add(4*24*time.Hour + 18*time.Hour)); !next.Equal(monday.Add(7*24*time.Hour + 9*time.Hour)) {
+		t.Errorf("Expected the window to open next Monday 09:00, got %v", next)
+	}
+}
+
+// TestNextRolloutTime tests the next opening time against a scan of every minute of the week.
+func TestNextRolloutTime(t *testing.T) {
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	if err != nil {
+		t.Skipf("Time zone data not available: %v", err)
+	}
+	lr := createTestLiveRoll()
+	lr.RolloutLocation = tokyo
+	for _, s := range []string{"Mon-Fri 09:00-17:00", "Sat 22:00-02:00"} {
+		w, _ := parseRolloutWindow(s)
+		lr.RolloutWindows = append(lr.RolloutWindows, w)
+	}
+	for _, s := range []string{"Mon 12:00-13:00", "Wed 00:00-24:00", "Sat 23:30-00:30"} {
+		w, _ := parseRolloutWindow(s)
+		lr.RolloutBlackouts = append(lr.RolloutBlackouts, w)
+	}
+	scan := func(t time.Time) time.Time {
+		next := t.Truncate(time.Minute)
+		for i := 0; i <= 7*24*60; i++ {
+			next = next.Add(time.Minute)
+			if lr.rolloutAllowed(next) {
+				return next
+			}
+		}
+		return time.Time{}
+	}
+
+	start := time.Date(2026, 10, 19, 0, 0, 0, 0, time.UTC)
+	for at := start; at.Before(start.Add(8 * 24 * time.Hour)); at = at.Add(97 * time.Minute) {
+		if got, want := lr.nextRolloutTime(at), scan(at); !got.Equal(want) {
+			t.Errorf("nextRolloutTime(%v) = %v, want %v", at, got, want)
+		}
+	}
+}
+
+// TestUpdateProcess_Deferred tests that interval updates outside the rollout windows defer the new ID.
+func TestUpdateProcess_Deferred(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.PullCmdStr = "true"
+	lr.IdCmdStr = "echo v2"
+	lr.currentID = "v1"
+	blackout, _ := parseRolloutWindow("* 00:00-00:00")
+	lr.RolloutBlackouts = []rolloutWindow{blackout}
+
+	result := lr.beginRollout(updateRequest{trigger: triggerInterval})
+	err := lr.updateProcess(updateRequest{trigger: triggerInterval})
+	lr.finishRollout(result, err)
+	if err != nil {
+		t.Fatalf("Expected update to be deferred, got: %v", err)
+	}
+	if last := lr.lastRolloutResult(); last.Outcome != outcomeDeferred {
+		t.Errorf("Expected outcome %q, got %q", outcomeDeferred, last.Outcome)
+	}
+	if st := lr.status(); st.DeferredID != "v2" {
+		t.Errorf("Expected deferred ID v2 in the status, got %q", st.DeferredID)
+	}
+}
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// shadowHeader is added to mirrored requests so that children can tell them apart.
+const shadowHeader = "X-Liveroll-Shadow"
+
+// ShadowSummary compares the responses of the candidate to mirrored requests with the primary responses.
+type ShadowSummary struct {
+	Requests         int            `json:"requests"`
+	Errors           int            `json:"errors"`
+	StatusMismatches int            `json:"status_mismatches"`
+	StatusDiffs      map[string]int `json:"status_diffs,omitempty"`
+	PrimaryLatency   time.Duration  `json:"primary_latency"`
+	ShadowLatency    time.Duration  `json:"shadow_latency"`
+}
+
+// shadowStats accumulates the comparison of mirrored requests during a shadow session.
+type shadowStats struct {
+	mutex          sync.Mutex
+	summary        ShadowSummary
+	primaryLatency time.Duration
+	shadowLatency  time.Duration
+	compared       int
+}
+
+// shadowResponse is the outcome of a request to the primary backend or the candidate.
+type shadowResponse struct {
+	status  int
+	latency time.Duration
+	err     error
+}
+
+func (s *shadowStats) reset() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.summary = ShadowSummary{}
+	s.primaryLatency = 0
+	s.shadowLatency = 0
+	s.compared = 0
+}
+
+func (s *shadowStats) record(primary shadowResponse, shadow shadowResponse) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.summary.Requests++
+	if shadow.err != nil {
+		s.summary.Errors++
+		return
+	}
+	s.compared++
+	s.primaryLatency += primary.latency
+	s.shadowLatency += shadow.latency
+	if primary.status != shadow.status {
+		s.summary.StatusMismatches++
+		if s.summary.StatusDiffs == nil {
+			s.summary.StatusDiffs = make(map[string]int)
+		}
+		s.summary.StatusDiffs[fmt.Sprintf("%d->%d", primary.status, shadow.status)]++
+	}
+}
+
+// snapshot returns the summary with average latencies.
+func (s *shadowStats) snapshot() ShadowSummary {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	summary := s.summary
+	if summary.StatusDiffs != nil {
+		summary.StatusDiffs = make(map[string]int, len(s.summary.StatusDiffs))
+		for k, v := range s.summary.StatusDiffs {
+			summary.StatusDiffs[k] = v
+		}
+	}
+	if s.compared > 0 {
+		summary.PrimaryLatency = s.primaryLatency / time.Duration(s.compared)
+		summary.ShadowLatency = s.shadowLatency / time.Duration(s.compared)
+	}
+	return summary
+}
+
+// startShadow starts a new shadow session for the candidate child.
+func (liveRoll *LiveRoll) startShadow(child *ChildProcess) {
+	if liveRoll.ShadowPercent <= 0 {
+		return
+	}
+	liveRoll.shadow.reset()
+	liveRoll.shadowTarget.Store(child)
+	log.Printf("Mirroring %.1f%% of requests to the candidate", liveRoll.ShadowPercent)
+}
+
+// finishShadow logs the summary of the shadow session and records it in the rollout result.
+func (liveRoll *LiveRoll) finishShadow() {
+	if liveRoll.ShadowPercent <= 0 {
+		return
+	}
+	liveRoll.shadowTarget.Store(nil)
+	summary := liveRoll.shadow.snapshot()
+	log.Printf("Shadow traffic summary: requests=%d errors=%d status_mismatches=%d %v primary_latency=%v shadow_latency=%v",
+		summary.Requests, summary.Errors, summary.StatusMismatches, summary.StatusDiffs,
+		summary.PrimaryLatency, summary.ShadowLatency)
+	liveRoll.updateRollout(func(r *RolloutResult) { r.Shadow = &summary })
+}
+
+// shadowPhase holds the new child process as a candidate receiving mirrored requests for ShadowDuration.
+func (liveRoll *LiveRoll) shadowPhase(child *ChildProcess) error {
+	liveRoll.childrenMutex.Lock()
+	liveRoll.candidate = child
+	liveRoll.childrenMutex.Unlock()
+	liveRoll.startShadow(child)
+	defer func() {
+		liveRoll.childrenMutex.Lock()
+		liveRoll.candidate = nil
+		liveRoll.childrenMutex.Unlock()
+		liveRoll.finishShadow()
+	}()
+
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	oldExited := childrenExited(liveRoll.otherChildren(child), stopWatching)
+
+	log.Printf("Shadowing traffic to port %d for %v", child.port, liveRoll.ShadowDuration)
+	select {
+	case <-time.After(liveRoll.ShadowDuration):
+		return nil
+	case <-oldExited:
+		log.Printf("The old child process(es) exited while shadowing to port %d. Ending the shadow phase", child.port)
+		return nil
+	case <-child.exited:
+		return fmt.Errorf("child process on port %d exited while shadowing", child.port)
+	}
+}
+
+// isShadowMethod reports whether requests with method are mirrored. The candidate shares
+// databases and downstream services with the primary, so only safe methods are mirrored unless
+// ShadowUnsafeMethods is set.
+func (liveRoll *LiveRoll) isShadowMethod(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return true
+	}
+	return liveRoll.ShadowUnsafeMethods
+}
+
+// withShadow mirrors ShadowPercent of the requests to the candidate, if any, and
+// compares its responses with the primary responses. Responses of the candidate are discarded.
+func (liveRoll *LiveRoll) withShadow(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		// The target is published by the update loop, so requests never wait for childrenMutex.
+		candidate := liveRoll.shadowTarget.Load()
+		if candidate == nil || rand.Float64()*100 >= liveRoll.ShadowPercent || !liveRoll.isShadowMethod(req.Method) ||
+			isLongLived(req) || req.ContentLength > liveRoll.ShadowMaxBody {
+			next.ServeHTTP(w, req)
+			return
+		}
+
+		body, err := io.ReadAll(io.LimitReader(req.Body, liveRoll.ShadowMaxBody+1))
+		if err != nil || int64(len(body)) > liveRoll.ShadowMaxBody {
+			// Too large (or unreadable) to mirror. Pass the request through untouched.
+			req.Body = io.NopCloser(io.MultiReader(bytes.NewReader(body), req.Body))
+			next.ServeHTTP(w, req)
+			return
+		}
+		req.Body = io.NopCloser(bytes.NewReader(body))
+
+		shadowDone := make(chan shadowResponse, 1)
+		go func() {
+			shadowDone <- liveRoll.sendShadowRequest(candidate, req, body)
+		}()
+
+		rec := &statusRecorder{ResponseWriter: w}
+		start := time.Now()
+		next.ServeHTTP(rec, req)
+		primary := shadowResponse{status: rec.status, latency: time.Since(start)}
+
+		go func() {
+			liveRoll.shadow.record(primary, <-shadowDone)
+		}()
+	})
+}
+
+// sendShadowRequest sends a copy of req to the candidate and discards the response.
+func (liveRoll *LiveRoll) sendShadowRequest(candidate *ChildProcess, req *http.Request, body []byte) shadowResponse {
+	ctx, cancel := context.WithTimeout(context.Background(), liveRoll.ShadowTimeout)
+	defer cancel()
+
+	shadowReq, err := http.NewRequestWithContext(ctx, req.Method,
+		backendURLForPort(candidate.port)+req.URL.RequestURI(), bytes.NewReader(body))
+	if err != nil {
+		return shadowResponse{err: err}
+	}
+	shadowReq.Header = req.Header.Clone()
+	shadowReq.Header.Set(shadowHeader, "1")
+	shadowReq.Host = req.Host
+
+	start := time.Now()
+	resp, err := http.DefaultTransport.RoundTrip(shadowReq)
+	if err != nil {
+		return shadowResponse{err: err}
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	return shadowResponse{status: resp.StatusCode, latency: time.Since(start)}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestWithShadow tests that requests are mirrored to the candidate and the responses are compared.
+func TestWithShadow(t *testing.T) {
+	mirrored := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get(shadowHeader) != "1" {
+			t.Errorf("Expected %s header on the mirrored request", shadowHeader)
+		}
+		mirrored <- r.URL.RequestURI() + " " + string(body)
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	lr := createTestLiveRoll()
+	lr.ShadowPercent = 100
+	lr.ShadowTimeout = time.Second
+	lr.ShadowMaxBody = 1024
+	lr.ShadowUnsafeMethods = true
+	lr.shadowTarget.Store(childForServer(t, ts))
+
+	handler := lr.withShadow(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.URL.Path == "/foo" && string(body) != "hello" {
+			t.Errorf("Expected primary to receive the body, got %q", body)
+		}
+		if r.URL.Path == "/large" && len(body) != 2048 {
+			t.Errorf("Expected primary to receive the whole body, got %d bytes", len(body))
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/foo?bar=1", strings.NewReader("hello")))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected the primary response, got %d", rec.Code)
+	}
+
+	select {
+	case got := <-mirrored:
+		if got != "/foo?bar=1 hello" {
+			t.Errorf("Unexpected mirrored request: %q", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Request was not mirrored")
+	}
+
+	var summary ShadowSummary
+	for i := 0; i < 100; i++ {
+		if summary = lr.shadow.snapshot(); summary.Requests > 0 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if summary.Requests != 1 || summary.StatusMismatches != 1 || summary.StatusDiffs["200->500"] != 1 {
+		t.Errorf("Unexpected shadow summary: %+v", summary)
+	}
+
+	// Requests with a body larger than the limit are not mirrored.
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/large", strings.NewReader(strings.Repeat("x", 2048))))
+	select {
+	case got := <-mirrored:
+		t.Errorf("Expected large request not to be mirrored, got %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Only safe methods are mirrored by default.
+	lr.ShadowUnsafeMethods = false
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodPost, "/foo", strings.NewReader("hello")))
+	select {
+	case got := <-mirrored:
+		t.Errorf("Expected POST not to be mirrored, got %q", got)
+	case <-time.After(100 * time.Millisecond):
+	}
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/bar", nil))
+	select {
+	case <-mirrored:
+	case <-time.After(time.Second):
+		t.Error("Expected GET to be mirrored")
+	}
+}
+
+// TestShadowPhase tests that the candidate is held for the shadow duration and cannot be promoted manually.
+func TestShadowPhase(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.ShadowPercent = 50
+	lr.ShadowDuration = 100 * time.Millisecond
+	child := &ChildProcess{port: 9102, id: "new", exited: make(chan struct{})}
+	lr.beginRollout(updateRequest{})
+
+	go func() {
+		waitForCandidate(t, lr)
+		if err := lr.decidePromotion(true); err == nil {
+			t.Error("Expected promote to be rejected during the shadow phase")
+		}
+	}()
+	if err := lr.shadowPhase(child); err != nil {
+		t.Errorf("Expected shadow phase to succeed, got: %v", err)
+	}
+	if lr.candidateStatus() != nil {
+		t.Error("Expected candidate to be cleared")
+	}
+
+	close(child.exited)
+	if err := lr.shadowPhase(child); err == nil {
+		t.Error("Expected error when the child exits during the shadow phase")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+)
+
+const (
+	statusOK           = "ok"
+	statusStarting     = "starting"
+	statusShuttingDown = "shutting_down"
+	statusDegraded     = "degraded"
+)
+
+// SlotStatus describes a child process port managed by liveroll.
+type SlotStatus struct {
+	Port         int        `json:"port"`
+	ID           string     `json:"id,omitempty"`
+	PID          int        `json:"pid,omitempty"`
+	Running      bool       `json:"running"`
+	InService    bool       `json:"in_service"`
+	Weight       int        `json:"weight,omitempty"`
+	EjectedUntil *time.Time `json:"ejected_until,omitempty"`
+	// Draining is true while the old child process finishes its in-flight requests.
+	Draining bool `json:"draining,omitempty"`
+	InFlight int  `json:"in_flight,omitempty"`
+	// LongLived is the number of WebSockets and event streams to the slot.
+	LongLived int `json:"long_lived,omitempty"`
+}
+
+// Status is the aggregate state reported by the status endpoint.
+type Status struct {
+	Status    string `json:"status"`
+	CurrentID string `json:"current_id"`
+	PinnedID  string `json:"pinned_id,omitempty"`
+	// AvailableID is a newer ID found while pinned.
+	AvailableID string `json:"available_id,omitempty"`
+	// DeferredID is a newer ID waiting for the rollout window to open at RolloutWindowOpensAt.
+	DeferredID           string     `json:"deferred_id,omitempty"`
+	RolloutWindowOpensAt *time.Time `json:"rollout_window_opens_at,omitempty"`
+	// Quarantined are the IDs that failed to roll out and are skipped by interval updates.
+	Quarantined []QuarantinedID `json:"quarantined,omitempty"`
+	Slots       []SlotStatus    `json:"slots"`
+	// Candidate is the child process waiting for promotion, if any.
+	Candidate *SlotStatus `json:"candidate,omitempty"`
+	// Shadow compares the candidate's responses to mirrored requests with the primary responses.
+	Shadow *ShadowSummary `json:"shadow,omitempty"`
+	// Queued is the number of requests waiting for a backend.
+	Queued int64 `json:"queued,omitempty"`
+	// Deployments are the successfully deployed IDs, oldest first.
+	Deployments []Deployment `json:"deployments"`
+	// LastRollout is the result of the last completed update process.
+	LastRollout *RolloutResult `json:"last_rollout,omitempty"`
+}
+
+// status collects the current state of liveroll and its child processes.
+func (liveRoll *LiveRoll) status() Status {
+	liveRoll.currentIDMutex.Lock()
+	current := liveRoll.currentID
+	liveRoll.currentIDMutex.Unlock()
+
+	if liveRoll.inShutdownProcess {
+		// childrenMutex is held for the whole shutdown process.
+		return Status{Status: statusShuttingDown, CurrentID: current, Slots: []SlotStatus{}}
+	}
+
+	slots := make(map[int]*SlotStatus)
+	for _, port := range []int{liveRoll.ChildPort1, liveRoll.ChildPort2} {
+		slots[port] = &SlotStatus{Port: port}
+	}
+
+	liveRoll.childrenMutex.Lock()
+	for port, child := range liveRoll.children {
+		slot, ok := slots[port]
+		if !ok {
+			slot = &SlotStatus{Port: port}
+			slots[port] = slot
+		}
+		slot.ID = child.id
+		slot.Running = true
+		slot.Draining = liveRoll.draining[port] != nil
+		if child.cmd != nil && child.cmd.Process != nil {
+			slot.PID = child.cmd.Process.Pid
+		}
+	}
+	for port, child := range liveRoll.draining {
+		slot, ok := slots[port]
+		if !ok {
+			slot = &SlotStatus{Port: port}
+			slots[port] = slot
+		}
+		if slot.Running {
+			// The child process is still registered, e.g. a failed canary.
+			continue
+		}
+		slot.ID = child.id
+		slot.Running = true
+		slot.Draining = true
+		if child.cmd != nil && child.cmd.Process != nil {
+			slot.PID = child.cmd.Process.Pid
+		}
+	}
+	liveRoll.childrenMutex.Unlock()
+
+	healthy := 0
+	liveRoll.backendURLsMutex.Lock()
+	for port := range liveRoll.backendURLs {
+		slot, ok := slots[port]
+		if !ok {
+			slot = &SlotStatus{Port: port}
+			slots[port] = slot
+		}
+		if until, ejected := liveRoll.ejectedUntil(port); ejected {
+			until := until
+			slot.EjectedUntil = &until
+			continue
+		}
+		slot.InService = true
+		slot.Weight = liveRoll.backendWeights[port]
+		if slot.Weight > 0 {
+			healthy++
+		}
+	}
+	liveRoll.backendURLsMutex.Unlock()
+
+	st := Status{
+		Status:      statusOK,
+		CurrentID:   current,
+		Slots:       make([]SlotStatus, 0, len(slots)),
+		PinnedID:    liveRoll.pinnedID(),
+		AvailableID: liveRoll.availableIDWhilePinned(),
+		Candidate:   liveRoll.candidateStatus(),
+		Deployments: liveRoll.deploymentHistory(),
+		LastRollout: liveRoll.lastRolloutResult(),
+		Quarantined: liveRoll.quarantinedIDs(),
+		Queued:      liveRoll.queued.Load(),
+	}
+	for _, slot := range slots {
+		slot.InFlight = liveRoll.inFlight.count(slot.Port)
+		slot.LongLived = liveRoll.longLived.count(slot.Port)
+		st.Slots = append(st.Slots, *slot)
+	}
+	sort.Slice(st.Slots, func(i, j int) bool { return st.Slots[i].Port < st.Slots[j].Port })
+
+	if st.DeferredID = liveRoll.deferredRolloutID(); st.DeferredID != "" {
+		if opensAt := liveRoll.nextRolloutTime(time.Now()); !opensAt.IsZero() {
+			st.RolloutWindowOpensAt = &opensAt
+		}
+	}
+
+	if st.Candidate != nil && liveRoll.ShadowPercent > 0 {
+		shadow := liveRoll.shadow.snapshot()
+		st.Shadow = &shadow
+	}
+
+	if healthy == 0 {
+		if liveRoll.startupDone.Load() {
+			st.Status = statusDegraded
+		} else {
+			st.Status = statusStarting
+		}
+	}
+	return st
+}
+
+// statusHandler answers with 200 when at least one healthy backend is registered, and 503 otherwise.
+func (liveRoll *LiveRoll) statusHandler(w http.ResponseWriter, _ *http.Request) {
+	st := liveRoll.status()
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	if st.Status == statusOK {
+		w.WriteHeader(http.StatusOK)
+	} else {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	if err := json.NewEncoder(w).Encode(st); err != nil {
+		log.Printf("Failed to write status response: %v", err)
+	}
+}
+
+// withStatusEndpoint serves the status endpoint on StatusPath and forwards everything else to next.
+func (liveRoll *LiveRoll) withStatusEndpoint(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path == liveRoll.StatusPath {
+			liveRoll.statusHandler(w, req)
+			return
+		}
+		next.ServeHTTP(w, req)
+	})
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// getStatus requests the status endpoint through withStatusEndpoint.
+func getStatus(t *testing.T, lr *LiveRoll) (int, Status) {
+	t.Helper()
+	handler := lr.withStatusEndpoint(http.NotFoundHandler())
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, lr.StatusPath, nil))
+
+	var st Status
+	if err := json.Unmarshal(rec.Body.Bytes(), &st); err != nil {
+		t.Fatalf("Failed to decode status body %q: %v", rec.Body.String(), err)
+	}
+	return rec.Code, st
+}
+
+// TestStatusEndpoint_Starting tests that the endpoint reports 503 before the first backend is registered.
+func TestStatusEndpoint_Starting(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t)
+	lr.StatusPath = "/_liveroll/health"
+
+	code, st := getStatus(t, lr)
+	if code != http.StatusServiceUnavailable || st.Status != statusStarting {
+		t.Errorf("Expected 503 starting, got %d %q", code, st.Status)
+	}
+	if len(st.Slots) != 2 {
+		t.Errorf("Expected both slots to be described, got %+v", st.Slots)
+	}
+}
+
+// TestStatusEndpoint_OK tests that the endpoint reports 200 while a backend is in service.
+func TestStatusEndpoint_OK(t *testing.T) {
+	lr := createTestLiveRollWithBackends(t, 9101)
+	lr.StatusPath = "/_liveroll/health"
+	lr.startupDone.Store(true)
+	lr.children[9101] = &ChildProcess{port: 9101, id: "v1"}
+	lr.currentID = "v1"
+
+	code, st := getStatus(t, lr)
+	if code != http.StatusOK || st.Status != statusOK {
+		t.Errorf("Expected 200 ok, got %d %q", code, st.Status)
+	}
+	if st.CurrentID != "v1" || !st.Slots[0].InService || st.Slots[0].ID != "v1" || st.Slots[1].Running {
+		t.Errorf("Unexpected status: %+v", st)
+	}
+
+	lr.removeBackendByPort(9101)
+	code, st = getStatus(t, lr)
+	if code != http.StatusServiceUnavailable || st.Status != statusDegraded {
+		t.Errorf("Expected 503 degraded, got %d %q", code, st.Status)
+	}
+}
+
+// TestStatusEndpoint_PassThrough tests that other paths are forwarded to the proxy.
+func TestStatusEndpoint_PassThrough(t *testing.T) {
+	lr := createTestLiveRoll()
+	lr.StatusPath = "/_liveroll/health"
+	handler := lr.withStatusEndpoint(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+	if rec.Code != http.StatusTeapot {
+		t.Errorf("Expected request to be forwarded, got %d", rec.Code)
+	}
+}
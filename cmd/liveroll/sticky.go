@@ -0,0 +1,100 @@
+package main
+
+import (
+	"log"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/vulcand/oxy/v2/roundrobin"
+	"github.com/vulcand/oxy/v2/roundrobin/stickycookie"
+)
+
+// loadBalancerOptions returns the options of the round-robin load balancer, enabling sticky
+// sessions when StickyCookie is set. The cookie holds a hash of the backend URL, so that the
+// ports of the child processes are not exposed.
+func (liveRoll *LiveRoll) loadBalancerOptions() []roundrobin.LBOption {
+	if liveRoll.StickyCookie == "" {
+		return nil
+	}
+	session := roundrobin.NewStickySessionWithOptions(liveRoll.StickyCookie, roundrobin.CookieOptions{
+		HTTPOnly: true,
+		Secure:   len(liveRoll.TLSCertFiles) > 0 || len(liveRoll.ACMEDomains) > 0,
+		SameSite: http.SameSiteLaxMode,
+	}).SetCookieValue(&stickycookie.HashValue{})
+	liveRoll.stickySession = session
+	return []roundrobin.LBOption{roundrobin.EnableStickySession(session)}
+}
+
+// stickTo replaces the sticky cookie set for the backend picked by the load balancer with
+// one for u, so that the client sticks to the backend that served the request.
+func (liveRoll *LiveRoll) stickTo(w http.ResponseWriter, u *url.URL) {
+	if liveRoll.stickySession == nil {
+		return
+	}
+	header := w.Header()
+	cookies := header.Values("Set-Cookie")
+	header.Del("Set-Cookie")
+	for _, line := range cookies {
+		if cookie, err := http.ParseSetCookie(line); err == nil && cookie.Name == liveRoll.StickyCookie {
+			continue
+		}
+		header.Add("Set-Cookie", line)
+	}
+	liveRoll.stickySession.StickBackend(u, w)
+}
+
+// drainStickySessions stops sending new clients to the children, while clients stuck to them
+// are still served, until StickyDrainTimeout expires, the children had no request for
+// StickyIdleTimeout, or they left the load balancer. Afterwards the children can be removed
+// and their clients stick to the new version.
+func (liveRoll *LiveRoll) drainStickySessions(children []*ChildProcess) {
+	if liveRoll.StickyCookie == "" || liveRoll.StickyDrainTimeout <= 0 {
+		return
+	}
+	var ports []int
+	for _, child := range children {
+		if _, ok := liveRoll.backendWeight(child.port); ok {
+			ports = append(ports, child.port)
+		}
+	}
+	if len(ports) == 0 || !liveRoll.hasServingBackendExcept(ports) {
+		return
+	}
+
+	for _, port := range ports {
+		liveRoll.setBackendWeight(port, 0)
+	}
+	log.Printf("Keeping sticky clients on the old child process(es) on port(s) %v for up to %v", ports, liveRoll.StickyDrainTimeout)
+	start := time.Now()
+	if waitUntil(func() bool {
+		for _, port := range ports {
+			if _, ok := liveRoll.backendWeight(port); !ok {
+				continue
+			}
+			idle := liveRoll.inFlight.idleSince(port, start)
+			if idle.IsZero() || time.Since(idle) < liveRoll.StickyIdleTimeout {
+				return false
+			}
+		}
+		return true
+	}, start.Add(liveRoll.StickyDrainTimeout)) {
+		log.Printf("Sticky clients left port(s) %v after %v", ports, time.Since(start).Round(time.Millisecond))
+	}
+}
+
+// hasServingBackendExcept reports whether a backend other than ports receives new requests.
+func (liveRoll *LiveRoll) hasServingBackendExcept(ports []int) bool {
+	liveRoll.backendURLsMutex.Lock()
+	defer liveRoll.backendURLsMutex.Unlock()
+	excluded := make(map[int]bool, len(ports))
+	for _, port := range ports {
+		excluded[port] = true
+	}
+	for port := range liveRoll.backendURLs {
+		if _, ejected := liveRoll.ejectedUntil(port); !ejected && liveRoll.backendWeights[port] > 0 && !excluded[port] {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// stickyGet requests url with cookies and returns the body and the cookies to send next.
+func stickyGet(t *testing.T, url string, cookies []*http.Cookie) (string, []*http.Cookie) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, url, nil)
+	for _, c := range cookies {
+		req.AddCookie(c)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("Failed to request: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if set := resp.Cookies(); len(set) > 0 {
+		cookies = set
+	}
+	return string(body), cookies
+}
+
+// TestStickySessions_Drain tests that sticky clients stay on a draining child until the sticky
+// drain timeout expires and then move to the new child, while new clients go to the new child.
+func TestStickySessions_Drain(t *testing.T) {
+	oldBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("old"))
+	}))
+	defer oldBackend.Close()
+	newBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	}))
+	defer newBackend.Close()
+
+	lr := createTestLiveRoll()
+	lr.StickyCookie = "liveroll_backend"
+	lr.StickyDrainTimeout = 500 * time.Millisecond
+	lr.StickyIdleTimeout = time.Minute
+	oldChild, proxyURL := createTestProxy(t, lr, oldBackend)
+
+	body, cookies := stickyGet(t, proxyURL, nil)
+	if body != "old" || len(cookies) == 0 || cookies[0].Name != lr.StickyCookie {
+		t.Fatalf("Expected the old child and a sticky cookie, got %q and %v", body, cookies)
+	}
+	lr.addBackend(childForServer(t, newBackend))
+	for i := 0; i < 3; i++ {
+		if body, _ := stickyGet(t, proxyURL, cookies); body != "old" {
+			t.Fatalf("Expected the sticky client to stay on the old child, got %q", body)
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		lr.drainAndStop([]*ChildProcess{oldChild})
+		close(done)
+	}()
+	time.Sleep(100 * time.Millisecond)
+	if body, _ := stickyGet(t, proxyURL, cookies); body != "old" {
+		t.Errorf("Expected the sticky client to stay on the draining child, got %q", body)
+	}
+	if body, _ := stickyGet(t, proxyURL, nil); body != "new" {
+		t.Errorf("Expected a new client to go to the new child, got %q", body)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the drain to complete")
+	}
+	body, cookies = stickyGet(t, proxyURL, cookies)
+	if body != "new" {
+		t.Errorf("Expected the sticky client to move to the new child after the drain, got %q", body)
+	}
+	if body, _ := stickyGet(t, proxyURL, cookies); body != "new" {
+		t.Errorf("Expected the client to stick to the new child, got %q", body)
+	}
+}
+
+// TestStickySessions_DrainIdle tests that the sticky drain ends once the old child had no
+// request for the idle timeout, instead of waiting for the sticky drain timeout.
+func TestStickySessions_DrainIdle(t *testing.T) {
+	oldBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("old"))
+	}))
+	defer oldBackend.Close()
+	newBackend := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("new"))
+	}))
+	defer newBackend.Close()
+
+	lr := createTestLiveRoll()
+	lr.StickyCookie = "liveroll_backend"
+	lr.StickyDrainTimeout = time.Minute
+	lr.StickyIdleTimeout = 300 * time.Millisecond
+	oldChild, proxyURL := createTestProxy(t, lr, oldBackend)
+	_, cookies := stickyGet(t, proxyURL, nil)
+	lr.addBackend(childForServer(t, newBackend))
+
+	start := time.Now()
+	done := make(chan struct{})
+	go func() {
+		lr.drainAndStop([]*ChildProcess{oldChild})
+		close(done)
+	}()
+	// Requests of the sticky client keep the old child from being idle.
+	for i := 0; i < 5; i++ {
+		time.Sleep(100 * time.Millisecond)
+		if body, _ := stickyGet(t, proxyURL, cookies); body != "old" {
+			t.Fatalf("Expected the sticky client to stay on the draining child, got %q", body)
+		}
+	}
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the drain to end once the old child was idle")
+	}
+	if elapsed := time.Since(start); elapsed < 700*time.Millisecond {
+		t.Errorf("Expected the drain to wait while the sticky client was active, ended after %v", elapsed)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// certificateFile is a certificate loaded from a cert/key file pair, reloaded when the files change.
+type certificateFile struct {
+	certFile string
+	keyFile  string
+	// modification times and sizes of the files the certificate was loaded from
+	certStat string
+	keyStat  string
+	cert     *tls.Certificate
+}
+
+// certificateStore serves the certificates of the listener, selected by SNI.
+type certificateStore struct {
+	mutex sync.RWMutex
+	files []*certificateFile
+}
+
+// fileStat returns a string that changes when the file at path is modified.
+func fileStat(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size()), nil
+}
+
+// newCertificateStore loads the certificates from the cert/key file pairs.
+func newCertificateStore(certFiles []string, keyFiles []string) (*certificateStore, error) {
+	store := &certificateStore{}
+	for i := range certFiles {
+		f := &certificateFile{certFile: certFiles[i], keyFile: keyFiles[i]}
+		if err := f.load(); err != nil {
+			return nil, err
+		}
+		store.files = append(store.files, f)
+	}
+	return store, nil
+}
+
+// load reads the certificate from its files.
+func (f *certificateFile) load() error {
+	certStat, err := fileStat(f.certFile)
+	if err != nil {
+		return err
+	}
+	keyStat, err := fileStat(f.keyFile)
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(f.certFile, f.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %v", f.certFile, err)
+	}
+	f.certStat, f.keyStat, f.cert = certStat, keyStat, &cert
+	return nil
+}
+
+// changed reports whether the files were modified since the certificate was loaded.
+func (f *certificateFile) changed() bool {
+	certStat, err := fileStat(f.certFile)
+	if err != nil {
+		return false
+	}
+	keyStat, err := fileStat(f.keyFile)
+	if err != nil {
+		return false
+	}
+	return certStat != f.certStat || keyStat != f.keyStat
+}
+
+// reload reloads the certificates whose files changed. A certificate that fails to load
+// (e.g. the key was not written yet) keeps being served until the next successful reload.
+func (s *certificateStore) reload() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, f := range s.files {
+		if !f.changed() {
+			continue
+		}
+		if err := f.load(); err != nil {
+			log.Printf("Failed to reload TLS certificate: %v", err)
+			continue
+		}
+		log.Printf("Reloaded TLS certificate %s", f.certFile)
+	}
+}
+
+// watch reloads changed certificates every interval.
+func (s *certificateStore) watch(interval time.Duration) {
+	for range time.Tick(interval) {
+		s.reload()
+	}
+}
+
+// getCertificate returns the first certificate valid for the client hello, or the first certificate.
+func (s *certificateStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	for _, f := range s.files {
+		if hello.SupportsCertificate(f.cert) == nil {
+			return f.cert, nil
+		}
+	}
+	return s.files[0].cert, nil
+}
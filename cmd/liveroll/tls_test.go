@@ -0,0 +1,105 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTestCertificate writes a self-signed certificate for host to dir and returns the file paths.
+func writeTestCertificate(t *testing.T, dir string, host string, serial int64) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: host},
+		DNSNames:     []string{host},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(365 * 24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile := filepath.Join(dir, host+".crt")
+	keyFile := filepath.Join(dir, host+".key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+// serialFor returns the serial number of the certificate served for serverName.
+func serialFor(t *testing.T, store *certificateStore, serverName string) int64 {
+	t.Helper()
+	cert, err := store.getCertificate(&tls.ClientHelloInfo{
+		ServerName:        serverName,
+		SignatureSchemes:  []tls.SignatureScheme{tls.ECDSAWithP256AndSHA256},
+		SupportedVersions: []uint16{tls.VersionTLS13},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert.Leaf.SerialNumber.Int64()
+}
+
+// TestCertificateStore tests the selection of certificates by SNI and their reload.
+func TestCertificateStore(t *testing.T) {
+	dir := t.TempDir()
+	certA, keyA := writeTestCertificate(t, dir, "a.example.com", 1)
+	certB, keyB := writeTestCertificate(t, dir, "b.example.com", 2)
+
+	store, err := newCertificateStore([]string{certA, certB}, []string{keyA, keyB})
+	if err != nil {
+		t.Fatalf("Failed to load certificates: %v", err)
+	}
+	if serial := serialFor(t, store, "b.example.com"); serial != 2 {
+		t.Errorf("Expected the certificate of b.example.com, got serial %d", serial)
+	}
+	if serial := serialFor(t, store, "unknown.example.com"); serial != 1 {
+		t.Errorf("Expected the first certificate for an unknown name, got serial %d", serial)
+	}
+
+	// A renewed certificate is served after the reload.
+	writeTestCertificate(t, dir, "b.example.com", 3)
+	future := time.Now().Add(time.Minute)
+	_ = os.Chtimes(certB, future, future)
+	store.reload()
+	if serial := serialFor(t, store, "b.example.com"); serial != 3 {
+		t.Errorf("Expected the renewed certificate, got serial %d", serial)
+	}
+
+	// A broken file keeps the previous certificate.
+	if err := os.WriteFile(keyB, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store.reload()
+	if serial := serialFor(t, store, "b.example.com"); serial != 3 {
+		t.Errorf("Expected the previous certificate to be kept, got serial %d", serial)
+	}
+
+	if _, err := newCertificateStore([]string{certA}, []string{keyB}); err == nil {
+		t.Error("Expected error for a mismatched key")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// WarmupRequest describes a request replayed against a new child process before it receives traffic.
+type WarmupRequest struct {
+	Method      string            `json:"method"`
+	Path        string            `json:"path"`
+	Headers     map[string]string `json:"headers"`
+	Body        string            `json:"body"`
+	Repeat      int               `json:"repeat"`
+	Concurrency int               `json:"concurrency"`
+}
+
+// loadWarmupRequests reads the list of warmup requests from a JSON file.
+func loadWarmupRequests(path string) ([]WarmupRequest, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var requests []WarmupRequest
+	if err := json.Unmarshal(data, &requests); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %v", path, err)
+	}
+	for i := range requests {
+		if requests[i].Method == "" {
+			requests[i].Method = http.MethodGet
+		}
+		if !strings.HasPrefix(requests[i].Path, "/") {
+			return nil, fmt.Errorf("warmup request %d: path must start with '/': %q", i, requests[i].Path)
+		}
+		if requests[i].Repeat <= 0 {
+			requests[i].Repeat = 1
+		}
+		if requests[i].Concurrency <= 0 {
+			requests[i].Concurrency = 1
+		}
+	}
+	return requests, nil
+}
+
+// warmup replays the configured warmup requests against the child process.
+// It returns an error only if WarmupRequireSuccess is set and any request failed.
+func (liveRoll *LiveRoll) warmup(child *ChildProcess) error {
+	if liveRoll.WarmupFile == "" {
+		return nil
+	}
+	requests, err := loadWarmupRequests(liveRoll.WarmupFile)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Warming up child process on port %d with %d request(s)", child.port, len(requests))
+	client := &http.Client{Timeout: liveRoll.WarmupTimeout}
+	baseURL := backendURLForPort(child.port)
+	var failures int64
+	for _, wr := range requests {
+		failures += runWarmupRequest(client, baseURL, wr)
+	}
+	if failures > 0 {
+		log.Printf("%d warmup request(s) failed for port %d", failures, child.port)
+		if liveRoll.WarmupRequireSuccess {
+			return fmt.Errorf("%d warmup request(s) failed", failures)
+		}
+	}
+	return nil
+}
+
+// runWarmupRequest sends wr.Repeat requests using wr.Concurrency workers and returns the number of failures.
+func runWarmupRequest(client *http.Client, baseURL string, wr WarmupRequest) int64 {
+	var failures atomic.Int64
+	jobs := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < wr.Concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range jobs {
+				if err := sendWarmupRequest(client, baseURL, wr); err != nil {
+					log.Printf("Warmup request %s %s failed: %v", wr.Method, wr.Path, err)
+					failures.Add(1)
+				}
+			}
+		}()
+	}
+	for i := 0; i < wr.Repeat; i++ {
+		jobs <- struct{}{}
+	}
+	close(jobs)
+	wg.Wait()
+	return failures.Load()
+}
+
+func sendWarmupRequest(client *http.Client, baseURL string, wr WarmupRequest) error {
+	req, err := http.NewRequest(wr.Method, baseURL+wr.Path, strings.NewReader(wr.Body))
+	if err != nil {
+		return err
+	}
+	for k, v := range wr.Headers {
+		req.Header.Set(k, v)
+	}
+	if host := req.Header.Get("Host"); host != "" {
+		req.Host = host
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code %d", resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// writeWarmupFile writes content to a temporary warmup file and returns its path.
+func writeWarmupFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "warmup.json")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write warmup file: %v", err)
+	}
+	return path
+}
+
+// childForServer returns a ChildProcess whose port points at the test server.
+func childForServer(t *testing.T, ts *httptest.Server) *ChildProcess {
+	t.Helper()
+	u, err := url.Parse(ts.URL)
+	if err != nil {
+		t.Fatalf("Failed to parse test server URL: %v", err)
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil {
+		t.Fatalf("Failed to parse test server port: %v", err)
+	}
+	return &ChildProcess{port: port}
+}
+
+// TestLoadWarmupRequests tests that defaults are applied to the loaded requests.
+func TestLoadWarmupRequests(t *testing.T) {
+	path := writeWarmupFile(t, `[{"path": "/"}, {"method": "POST", "path": "/api", "repeat": 5, "concurrency": 2}]`)
+
+	requests, err := loadWarmupRequests(path)
+	if err != nil {
+		t.Fatalf("Expected no error, got: %v", err)
+	}
+	if len(requests) != 2 {
+		t.Fatalf("Expected 2 requests, got %d", len(requests))
+	}
+	if requests[0].Method != http.MethodGet || requests[0].Repeat != 1 || requests[0].Concurrency != 1 {
+		t.Errorf("Unexpected defaults: %+v", requests[0])
+	}
+	if requests[1].Method != http.MethodPost || requests[1].Repeat != 5 || requests[1].Concurrency != 2 {
+		t.Errorf("Unexpected request: %+v", requests[1])
+	}
+
+	if _, err := loadWarmupRequests(writeWarmupFile(t, `[{"path": "api"}]`)); err == nil {
+		t.Error("Expected error for a path without leading slash, got nil")
+	}
+}
+
+// TestWarmup replays the requests and checks the repeat count and headers.
+func TestWarmup(t *testing.T) {
+	var count atomic.Int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/warm" && r.Header.Get("X-Warmup") == "1" {
+			count.Add(1)
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	lr := createTestLiveRoll()
+	lr.WarmupFile = writeWarmupFile(t, `[{"path": "/warm", "headers": {"X-Warmup": "1"}, "repeat": 10, "concurrency": 3}]`)
+	lr.WarmupTimeout = 1 * time.Second
+	lr.WarmupRequireSuccess = true
+
+	if err := lr.warmup(childForServer(t, ts)); err != nil {
+		t.Errorf("Expected warmup to succeed, got: %v", err)
+	}
+	if count.Load() != 10 {
+		t.Errorf("Expected 10 warmup requests, got %d", count.Load())
+	}
+}
+
+// TestWarmup_RequireSuccess tests that failed warmup requests only abort when success is required.
+func TestWarmup_RequireSuccess(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer ts.Close()
+
+	lr := createTestLiveRoll()
+	lr.WarmupFile = writeWarmupFile(t, `[{"path": "/"}]`)
+	lr.WarmupTimeout = 1 * time.Second
+
+	if err := lr.warmup(childForServer(t, ts)); err != nil {
+		t.Errorf("Expected failures to be ignored, got: %v", err)
+	}
+
+	lr.WarmupRequireSuccess = true
+	if err := lr.warmup(childForServer(t, ts)); err == nil {
+		t.Error("Expected warmup to fail, but it succeeded")
+	}
+}
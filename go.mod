@@ -2,7 +2,11 @@ module github.com/tokuhirom/liveroll
 
 go 1.23.5
 
-require github.com/vulcand/oxy/v2 v2.0.2
+require (
+	github.com/vulcand/oxy/v2 v2.0.2
+	golang.org/x/crypto v0.32.0
+	golang.org/x/net v0.34.0
+)
 
 require (
 	github.com/HdrHistogram/hdrhistogram-go v1.1.2 // indirect
@@ -12,8 +16,7 @@ require (
 	github.com/segmentio/fasthash v1.0.3 // indirect
 	github.com/sirupsen/logrus v1.9.3 // indirect
 	github.com/vulcand/predicate v1.3.0 // indirect
-	golang.org/x/crypto v0.32.0 // indirect
-	golang.org/x/net v0.34.0 // indirect
 	golang.org/x/sys v0.29.0 // indirect
 	golang.org/x/term v0.28.0 // indirect
+	golang.org/x/text v0.21.0 // indirect
 )